@@ -2,9 +2,12 @@
 package basic
 
 import (
-	"fmt"
 	"reflect"
-	"runtime/debug"
+)
+
+const (
+	// the source of reports by the catch functions.
+	_REPORT_SOURCE = "basic"
 )
 
 // The callback function.
@@ -20,11 +23,11 @@ func Throw(err error) {
 	}
 }
 
-// Catch the error throw by the function if it paniced.
+// Catch the error throw by the function if it paniced, panics of any type are converted to errors.
 func Catch(f func()) (err error) {
 	defer func() {
-		if e, ok := recover().(error); ok {
-			err = e
+		if info := recover(); info != nil {
+			err = PanicError(info)
 		}
 	}()
 
@@ -32,23 +35,17 @@ func Catch(f func()) (err error) {
 	return
 }
 
-// Pack runtime error msg for log.
+// Pack runtime error msg and send it to the reporter, see SetReporter.
 func PackErrorMsg(err error, args interface{}) map[string]interface{} {
-	msg := make(map[string]interface{}, 3)
-	msg["err"] = err.Error()
-	msg["args"] = args
-	msg["trace"] = string(debug.Stack())
-	// mail.SendMsg(msg)
-	fmt.Println(msg)
-	return msg
+	return Report(_REPORT_SOURCE, err, args).Map()
 }
 
 // Catch the error throw by the function with interface arguments.
 func CatchWithParams(f FuncCallback, args ...interface{}) (err error) {
 	defer func() {
-		if e, ok := recover().(error); ok {
-			err = e
-			PackErrorMsg(e, args)
+		if info := recover(); info != nil {
+			err = PanicError(info)
+			PackErrorMsg(err, args)
 		}
 	}()
 
@@ -58,9 +55,9 @@ func CatchWithParams(f FuncCallback, args ...interface{}) (err error) {
 
 func CatchWithReflect(f interface{}, args ...interface{}) (err error) {
 	defer func() {
-		if e, ok := recover().(error); ok {
-			err = e
-			PackErrorMsg(e, args)
+		if info := recover(); info != nil {
+			err = PanicError(info)
+			PackErrorMsg(err, args)
 		}
 	}()
 	_f := reflect.ValueOf(f)
@@ -75,9 +72,9 @@ func CatchWithReflect(f interface{}, args ...interface{}) (err error) {
 // Catch Func for post worker.
 func CatchFunc(f Func, args ...interface{}) (err error, res interface{}) {
 	defer func() {
-		if e, ok := recover().(error); ok {
-			err = e
-			res = PackErrorMsg(e, args)
+		if info := recover(); info != nil {
+			err = PanicError(info)
+			res = PackErrorMsg(err, args)
 		}
 	}()
 
@@ -1,6 +1,7 @@
 package basic
 
 import (
+	"errors"
 	"fmt"
 	"testing"
 
@@ -14,13 +15,23 @@ func TestCatch(t *testing.T) {
 	assert.Equal(t, e1.Error(), "bad1")
 
 	e2 := CatchWithParams(func(args ...interface{}) {
-		Throw(fmt.Errorf(args[0].(string)))
+		Throw(errors.New(args[0].(string)))
 	}, "bad2")
 	assert.Equal(t, e2.Error(), "bad2")
 
 	e3, _ := CatchFunc(func(args ...interface{}) (res interface{}) {
-		Throw(fmt.Errorf(args[0].(string)))
+		Throw(errors.New(args[0].(string)))
 		return nil
 	}, "bad3")
 	assert.Equal(t, e3.Error(), "bad3")
+
+	// panics of any type are caught.
+	e4 := Catch(func() {
+		panic("bad4")
+	})
+	assert.Equal(t, e4.Error(), "bad4")
+	e5 := CatchWithParams(func(args ...interface{}) {
+		panic(args[0])
+	}, 5)
+	assert.Equal(t, e5.Error(), "5")
 }
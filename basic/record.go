@@ -0,0 +1,96 @@
+// Sinks of records shared by the reporters and exporters, a ring of the latest records and a JSON-lines file.
+package basic
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// Keep the latest records in memory, it's safe for concurrent use.
+type Ring[T any] struct {
+	lock    sync.Mutex
+	records []T
+	next    int
+	full    bool
+}
+
+// Write the records to a file as JSON lines, it's safe for concurrent use.
+type JSONLines struct {
+	lock sync.Mutex
+	file *os.File
+}
+
+func NewRing[T any](size int) *Ring[T] {
+	if size <= 0 {
+		size = 1
+	}
+	return &Ring[T]{records: make([]T, size)}
+}
+
+// Add the record, the oldest one is overwritten if the ring is full.
+func (this *Ring[T]) Add(record T) {
+	defer this.lock.Unlock()
+	this.lock.Lock()
+	this.records[this.next] = record
+	if this.next++; this.next == len(this.records) {
+		this.next = 0
+		this.full = true
+	}
+}
+
+// The records from the oldest to the latest.
+func (this *Ring[T]) List() []T {
+	defer this.lock.Unlock()
+	this.lock.Lock()
+	if !this.full {
+		return append([]T(nil), this.records[:this.next]...)
+	}
+	return append(append([]T(nil), this.records[this.next:]...), this.records[:this.next]...)
+}
+
+func (this *Ring[T]) Len() int {
+	defer this.lock.Unlock()
+	this.lock.Lock()
+	if this.full {
+		return len(this.records)
+	}
+	return this.next
+}
+
+func (this *Ring[T]) Clear() {
+	defer this.lock.Unlock()
+	this.lock.Lock()
+	var zero T
+	this.next = 0
+	this.full = false
+	for i := range this.records {
+		this.records[i] = zero
+	}
+}
+
+func NewJSONLines(filePath string) (*JSONLines, error) {
+	f, err := NewFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONLines{file: f}, nil
+}
+
+// Append the record as a line, nothing is written if it can't be marshaled.
+func (this *JSONLines) Write(record interface{}) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	defer this.lock.Unlock()
+	this.lock.Lock()
+	_, err = this.file.Write(append(data, '\n'))
+	return err
+}
+
+func (this *JSONLines) Close() error {
+	defer this.lock.Unlock()
+	this.lock.Lock()
+	return this.file.Close()
+}
@@ -0,0 +1,35 @@
+package basic
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRing(t *testing.T) {
+	ring := NewRing[int](2)
+	assert.Equal(t, 0, ring.Len())
+	for i := 1; i <= 3; i++ {
+		ring.Add(i)
+	}
+	assert.Equal(t, 2, ring.Len())
+	assert.Equal(t, []int{2, 3}, ring.List())
+	ring.Clear()
+	assert.Equal(t, 0, len(ring.List()))
+}
+
+func TestJSONLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.log")
+	lines, err := NewJSONLines(path)
+	assert.Nil(t, err)
+	assert.Nil(t, lines.Write(map[string]int{"a": 1}))
+	// nothing is written for the record which can't be marshaled.
+	assert.NotNil(t, lines.Write(func() {}))
+	assert.Nil(t, lines.Close())
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{`{"a":1}`}, strings.Split(strings.TrimSpace(string(data)), "\n"))
+}
@@ -0,0 +1,238 @@
+// Reporters of runtime errors, the panics recovered by basic, post and timer are reported here.
+package basic
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"regexp"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// the signatures kept by AggregateReporter before the expired ones are removed.
+	AGGREGATE_SIGNATURE_NUM = 1024
+)
+
+var (
+	// the reporter for all runtime errors, LogReporter by default.
+	reporter atomic.Value
+	// the goroutine ids, arguments and pc offsets which differ between the same stacks.
+	stackNoise = regexp.MustCompile(`goroutine \d+|0x[0-9a-f]+`)
+)
+
+type ErrorReport struct {
+	Err    string      `json:"err"`
+	Source string      `json:"source"`
+	Args   interface{} `json:"args"`
+	Trace  string      `json:"trace"`
+	// the hash of the stack without addresses, the same errors have the same signature.
+	Signature string    `json:"signature"`
+	Time      time.Time `json:"time"`
+	// the number of the same reports suppressed in the last window of AggregateReporter.
+	Suppressed uint64 `json:"suppressed,omitempty"`
+}
+
+// Reporter receives the reports of runtime errors, it must be safe for concurrent use.
+type Reporter interface {
+	Report(report ErrorReport)
+}
+
+// Print the reports with the standard logger.
+type LogReporter struct{}
+
+// Write the reports to a file as JSON lines.
+type FileReporter struct {
+	lines *JSONLines
+}
+
+// Pass at most burst reports of the same signature to next in each window.
+type AggregateReporter struct {
+	next   Reporter
+	window time.Duration
+	burst  int
+	lock   sync.Mutex
+	seen   map[string]*aggregate
+}
+
+type aggregate struct {
+	start      time.Time
+	count      int
+	suppressed uint64
+}
+
+// Keep the latest reports in memory for inspection.
+type RingReporter struct {
+	ring *Ring[ErrorReport]
+}
+
+// Send the reports to all the reporters.
+type MultiReporter []Reporter
+
+func init() {
+	SetReporter(LogReporter{})
+}
+
+// Replace the reporter, nil means LogReporter.
+func SetReporter(r Reporter) {
+	if r == nil {
+		r = LogReporter{}
+	}
+	reporter.Store(&r)
+}
+
+func GetReporter() Reporter {
+	return *reporter.Load().(*Reporter)
+}
+
+// Report the error with the stack of the caller, it's called in the deferred function for a panic.
+func Report(source string, err error, args interface{}) ErrorReport {
+	trace := string(debug.Stack())
+	report := ErrorReport{
+		Err:       err.Error(),
+		Source:    source,
+		Args:      args,
+		Trace:     trace,
+		Signature: StackSignature(trace),
+		Time:      time.Now(),
+	}
+	GetReporter().Report(report)
+	return report
+}
+
+// The hash of the stack without the goroutine id and addresses.
+func StackSignature(trace string) string {
+	h := fnv.New64a()
+	h.Write([]byte(stackNoise.ReplaceAllString(trace, "")))
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// Convert the recovered value of any type to an error.
+func PanicError(info interface{}) error {
+	switch e := info.(type) {
+	case error:
+		return e
+	case string:
+		return errors.New(e)
+	}
+	return fmt.Errorf("%+v", info)
+}
+
+// The map format of the report, it's kept for PackErrorMsg.
+func (this ErrorReport) Map() map[string]interface{} {
+	return map[string]interface{}{
+		"err":   this.Err,
+		"args":  this.Args,
+		"trace": this.Trace,
+	}
+}
+
+func (this LogReporter) Report(report ErrorReport) {
+	log.Printf("[Error] source=%s err=%s args=%+v suppressed=%d\n%s\n", report.Source, report.Err, report.Args, report.Suppressed, report.Trace)
+}
+
+func NewFileReporter(filePath string) (*FileReporter, error) {
+	lines, err := NewJSONLines(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return &FileReporter{lines: lines}, nil
+}
+
+func (this *FileReporter) Report(report ErrorReport) {
+	if err := this.lines.Write(report); err != nil {
+		// the arguments may be functions or channels.
+		report.Args = fmt.Sprintf("%+v", report.Args)
+		if err = this.lines.Write(report); err != nil {
+			log.Printf("[FileReporter] %v\n", err)
+		}
+	}
+}
+
+func (this *FileReporter) Close() error {
+	return this.lines.Close()
+}
+
+func NewAggregateReporter(next Reporter, window time.Duration, burst int) *AggregateReporter {
+	if burst <= 0 {
+		burst = 1
+	}
+	return &AggregateReporter{
+		next:   next,
+		window: window,
+		burst:  burst,
+		seen:   make(map[string]*aggregate),
+	}
+}
+
+func (this *AggregateReporter) Report(report ErrorReport) {
+	this.lock.Lock()
+	a := this.seen[report.Signature]
+	if a == nil || report.Time.Sub(a.start) >= this.window {
+		if a != nil {
+			report.Suppressed = a.suppressed
+		} else if len(this.seen) >= AGGREGATE_SIGNATURE_NUM {
+			this.expire(report.Time)
+		}
+		a = &aggregate{start: report.Time}
+		this.seen[report.Signature] = a
+	}
+	if a.count++; a.count > this.burst {
+		a.suppressed++
+		this.lock.Unlock()
+		return
+	}
+	this.lock.Unlock()
+	this.next.Report(report)
+}
+
+// Remove the signatures whose window is over, it must be called with the lock.
+func (this *AggregateReporter) expire(now time.Time) {
+	for sig, a := range this.seen {
+		if now.Sub(a.start) >= this.window {
+			delete(this.seen, sig)
+		}
+	}
+}
+
+// The number of reports suppressed in the current windows.
+func (this *AggregateReporter) Suppressed() (suppressed uint64) {
+	defer this.lock.Unlock()
+	this.lock.Lock()
+	for _, a := range this.seen {
+		suppressed += a.suppressed
+	}
+	return
+}
+
+func NewRingReporter(size int) *RingReporter {
+	return &RingReporter{ring: NewRing[ErrorReport](size)}
+}
+
+func (this *RingReporter) Report(report ErrorReport) {
+	this.ring.Add(report)
+}
+
+// The reports from the oldest to the latest.
+func (this *RingReporter) List() []ErrorReport {
+	return this.ring.List()
+}
+
+func (this *RingReporter) Len() int {
+	return this.ring.Len()
+}
+
+func (this *RingReporter) Clear() {
+	this.ring.Clear()
+}
+
+func (this MultiReporter) Report(report ErrorReport) {
+	for _, r := range this {
+		r.Report(report)
+	}
+}
@@ -0,0 +1,117 @@
+package basic
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func fail(i int) error {
+	return Catch(func() {
+		panic(i)
+	})
+}
+
+func TestRingReporter(t *testing.T) {
+	ring := NewRingReporter(2)
+	SetReporter(ring)
+	defer SetReporter(nil)
+
+	CatchWithParams(func(args ...interface{}) {
+		panic("bad")
+	}, 1)
+	assert.Equal(t, 1, ring.Len())
+	report := ring.List()[0]
+	assert.Equal(t, "bad", report.Err)
+	assert.Equal(t, _REPORT_SOURCE, report.Source)
+	assert.Equal(t, []interface{}{1}, report.Args)
+	assert.Contains(t, report.Trace, "TestRingReporter")
+
+	for i := 2; i <= 3; i++ {
+		Report("test", errors.New("bad"), i)
+	}
+	assert.Equal(t, 2, ring.Len())
+	list := ring.List()
+	assert.Equal(t, 2, list[0].Args)
+	assert.Equal(t, 3, list[1].Args)
+	ring.Clear()
+	assert.Equal(t, 0, len(ring.List()))
+}
+
+func TestStackSignature(t *testing.T) {
+	var reports []ErrorReport
+	ring := NewRingReporter(4)
+	SetReporter(ring)
+	defer SetReporter(nil)
+	for i := 0; i < 2; i++ {
+		go func() {
+			defer func() {
+				Report("test", PanicError(recover()), nil)
+			}()
+			panic("bad")
+		}()
+		time.Sleep(10 * time.Millisecond)
+	}
+	reports = ring.List()
+	assert.Equal(t, 2, len(reports))
+	// the same panic in different goroutines.
+	assert.Equal(t, reports[0].Signature, reports[1].Signature)
+	assert.NotEqual(t, reports[0].Signature, Report("test", errors.New("bad"), nil).Signature)
+}
+
+func TestAggregateReporter(t *testing.T) {
+	ring := NewRingReporter(16)
+	agg := NewAggregateReporter(ring, 50*time.Millisecond, 2)
+	report := ErrorReport{Err: "bad", Signature: "a", Time: time.Now()}
+	for i := 0; i < 5; i++ {
+		agg.Report(report)
+	}
+	agg.Report(ErrorReport{Err: "bad", Signature: "b", Time: time.Now()})
+	assert.Equal(t, 3, ring.Len())
+	assert.Equal(t, uint64(3), agg.Suppressed())
+
+	// the suppressed number is reported in the next window.
+	report.Time = report.Time.Add(50 * time.Millisecond)
+	agg.Report(report)
+	list := ring.List()
+	assert.Equal(t, 4, len(list))
+	assert.Equal(t, uint64(3), list[3].Suppressed)
+	assert.Equal(t, uint64(0), agg.Suppressed())
+}
+
+func TestFileReporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "errors.log")
+	file, err := NewFileReporter(path)
+	assert.Nil(t, err)
+	SetReporter(MultiReporter{file, NewRingReporter(1)})
+	defer SetReporter(nil)
+
+	fail(1)
+	CatchWithParams(func(args ...interface{}) {
+		panic("bad")
+	}, func() {})
+	assert.Nil(t, file.Close())
+
+	f, err := os.Open(path)
+	assert.Nil(t, err)
+	defer f.Close()
+	var reports []ErrorReport
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var report ErrorReport
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &report))
+		reports = append(reports, report)
+	}
+	// Catch is not reported.
+	assert.Equal(t, 1, len(reports))
+	assert.Equal(t, "bad", reports[0].Err)
+	// the function can't be marshaled, so it's formatted.
+	assert.IsType(t, "", reports[0].Args)
+}
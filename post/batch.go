@@ -0,0 +1,430 @@
+// Batch submission of jobs, the jobs of each lane are put with one EsQueue.Puts in most cases.
+package post
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// the error of the jobs which are not queued because the other ones of an all-or-nothing batch failed.
+	ErrBatchAborted = errors.New("post: batch aborted")
+)
+
+type BatchJob struct {
+	Func   interface{}
+	Params []interface{}
+	// the job is skipped if Ctx is done before execution, it's optional.
+	Ctx      context.Context
+	Priority Priority
+}
+
+// The errors of the jobs which are not queued, by index in the batch.
+type BatchError struct {
+	Errors map[int]error
+}
+
+type batchItem struct {
+	index     int
+	o         *RpcObject
+	msg       *QueueMsg
+	journaled bool
+}
+
+func (this *BatchError) add(index int, err error) {
+	if this.Errors == nil {
+		this.Errors = make(map[int]error)
+	}
+	this.Errors[index] = err
+}
+
+// The error of the batch, nil if all the jobs are queued.
+func (this *BatchError) err() error {
+	if len(this.Errors) == 0 {
+		return nil
+	}
+	return this
+}
+
+// Abort the rest jobs of an all-or-nothing batch.
+func (this *BatchError) abort(num int) error {
+	for i := 0; i < num; i++ {
+		if _, ok := this.Errors[i]; !ok {
+			this.add(i, ErrBatchAborted)
+		}
+	}
+	return this
+}
+
+// The indexes of the failed jobs in order.
+func (this *BatchError) Indexes() []int {
+	indexes := make([]int, 0, len(this.Errors))
+	for i := range this.Errors {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
+func (this *BatchError) Error() string {
+	indexes := this.Indexes()
+	return fmt.Sprintf("post: %d jobs of the batch failed, job %d: %v", len(indexes), indexes[0], this.Errors[indexes[0]])
+}
+
+// The errors of the jobs, errors.Is and errors.As check all of them.
+func (this *BatchError) Unwrap() []error {
+	errs := make([]error, 0, len(this.Errors))
+	for _, i := range this.Indexes() {
+		errs = append(errs, this.Errors[i])
+	}
+	return errs
+}
+
+// Put the jobs to the running objects selected by the dispatcher, it returns the number of jobs queued
+// and a *BatchError for the failed ones. The jobs delayed or dropped by limits are counted as queued.
+// No job is queued if any of an all-or-nothing batch fails, the other senders of the objects are excluded
+// until the batch is queued, and the batch fails with ErrQueueFull if the queues haven't enough space,
+// unless the overflow policy is OVERFLOW_SPILL. The jobs of an all-or-nothing batch are delayed or dropped
+// by limits only after the others are queued.
+func (this *Post) PutQueueBatch(jobs []BatchJob, allOrNothing bool) (queued int, err error) {
+	items := make([]batchItem, 0, len(jobs))
+	for i, job := range jobs {
+		o := this.pick(job.Func)
+		if o == nil {
+			this.recycle(items)
+			return 0, this.noWorkersError()
+		}
+		msg := o.newMsg(job.Func, nil, job.Params, nil, false)
+		msg.Ctx = job.Ctx
+		msg.Priority = job.Priority
+		items = append(items, batchItem{index: i, o: o, msg: msg})
+	}
+	if allOrNothing {
+		return this.putAllOrNothing(len(jobs), items)
+	}
+
+	batch := &BatchError{}
+	admitted := items[:0]
+	for _, item := range items {
+		ok, journaled, err := this.admit(item.o, item.msg)
+		switch {
+		case err != nil:
+			batch.add(item.index, err)
+		case ok:
+			item.journaled = journaled
+			admitted = append(admitted, item)
+		default:
+			queued++
+		}
+	}
+	queued += this.sendBatch(admitted, false, batch)
+	return queued, batch.err()
+}
+
+// Put the jobs of an all-or-nothing batch, the jobs not put now by limits are settled after the others are queued.
+func (this *Post) putAllOrNothing(num int, items []batchItem) (queued int, err error) {
+	batch := &BatchError{}
+	admitted := make([]batchItem, 0, len(items))
+	var settles []func()
+	for _, item := range items {
+		ok, journaled, settle, err := this.deferAdmit(item.o, item.msg)
+		switch {
+		case err != nil:
+			batch.add(item.index, err)
+		case ok:
+			item.journaled = journaled
+			admitted = append(admitted, item)
+		default:
+			settles = append(settles, settle)
+		}
+	}
+	if len(batch.Errors) > 0 {
+		for _, item := range admitted {
+			item.fail()
+		}
+		return 0, batch.abort(num)
+	}
+
+	objects := this.lockObjects(admitted)
+	if err = reserve(admitted); err != nil {
+		this.unlockObjects(objects)
+		for _, item := range admitted {
+			item.fail()
+		}
+		return 0, err
+	}
+	queued = this.sendBatch(admitted, true, batch)
+	this.unlockObjects(objects)
+	for _, settle := range settles {
+		if settle != nil {
+			settle()
+		}
+		queued++
+	}
+	return queued, batch.err()
+}
+
+// Return the messages of the batch to the pools of objects.
+func (this *Post) recycle(items []batchItem) {
+	for _, item := range items {
+		item.o.releaseMsg(item.msg)
+	}
+}
+
+// Exclude the other senders of the objects of the batch, and keep the objects from closing until unlocked.
+func (this *Post) lockObjects(items []batchItem) []*RpcObject {
+	var objects []*RpcObject
+	locked := make(map[*RpcObject]bool)
+	// the batches lock the objects one at a time, so they never wait for each other.
+	this.batchLock.Lock()
+	for _, item := range items {
+		if o := item.o; !locked[o] {
+			locked[o] = true
+			atomic.AddInt64(&o.putting, 1)
+			o.sendLock.Lock()
+			objects = append(objects, o)
+		}
+	}
+	return objects
+}
+
+func (this *Post) unlockObjects(objects []*RpcObject) {
+	for _, o := range objects {
+		o.sendLock.Unlock()
+		atomic.AddInt64(&o.putting, -1)
+	}
+	this.batchLock.Unlock()
+}
+
+// Check if the lanes have enough space for the batch, it must be called with the objects locked.
+func reserve(items []batchItem) error {
+	type lane struct {
+		o        *RpcObject
+		priority Priority
+	}
+	need := make(map[lane]uint64)
+	for _, item := range items {
+		need[lane{item.o, item.msg.Priority}]++
+	}
+	for l, n := range need {
+		if atomic.LoadInt32(&l.o.closed) == 1 {
+			return ErrClosed
+		}
+		if OverflowPolicy(atomic.LoadInt32(&l.o.overflow.policy)) == OVERFLOW_SPILL {
+			continue
+		}
+		q := l.o.lane(l.priority)
+		if quantity := q.Quantity(); quantity+n+2 > q.Capacity() {
+			return queueFullError(quantity)
+		}
+	}
+	return nil
+}
+
+// Release the limiter and the journal record of the message which isn't queued.
+func (this *batchItem) fail() {
+	this.msg.release()
+	if this.journaled {
+		this.msg.complete()
+	}
+}
+
+// Put the admitted messages to their objects, the objects are locked by the batch if exclusive is true.
+func (this *Post) sendBatch(items []batchItem, exclusive bool, batch *BatchError) (queued int) {
+	var objects []*RpcObject
+	groups := make(map[*RpcObject][]batchItem)
+	for _, item := range items {
+		if _, ok := groups[item.o]; !ok {
+			objects = append(objects, item.o)
+		}
+		groups[item.o] = append(groups[item.o], item)
+	}
+	for _, o := range objects {
+		group := groups[o]
+		msgs := make([]*QueueMsg, len(group))
+		for i, item := range group {
+			msgs[i] = item.msg
+		}
+		for i, err := range o.putBatch(msgs, exclusive) {
+			if err == ErrClosed {
+				// the object is retired meanwhile.
+				if other := this.pick(msgs[i].Func); other == nil {
+					err = this.noWorkersError()
+				} else {
+					err = this.send(other, msgs[i])
+				}
+			}
+			if err != nil {
+				group[i].fail()
+				batch.add(group[i].index, err)
+				continue
+			}
+			queued++
+		}
+	}
+	return
+}
+
+// Put the messages to the lanes, the ones beyond the space are put one by one according to the overflow policy.
+// The object is locked by the batch if exclusive is true, the space is reserved, so only the overflow slice
+// is used for the rest. The errors are in the order of msgs.
+func (this *RpcObject) putBatch(msgs []*QueueMsg, exclusive bool) []error {
+	errs := make([]error, len(msgs))
+	if !exclusive {
+		atomic.AddInt64(&this.putting, 1)
+		defer atomic.AddInt64(&this.putting, -1)
+		if atomic.LoadInt32(&this.closed) == 1 {
+			for i := range errs {
+				errs[i] = ErrClosed
+			}
+			atomic.AddUint64(&this.stats.putFailed, uint64(len(msgs)))
+			return errs
+		}
+	}
+
+	var vals [PRIORITY_NUM][]interface{}
+	var indexes [PRIORITY_NUM][]int
+	for i, msg := range msgs {
+		msg.trace()
+		vals[msg.Priority] = append(vals[msg.Priority], msg)
+		indexes[msg.Priority] = append(indexes[msg.Priority], i)
+	}
+	for priority := range vals {
+		var n uint64
+		// keep the order when the overflow slice is not empty.
+		if atomic.LoadInt64(&this.overflow.spilled) == 0 {
+			n = this.puts(Priority(priority), vals[priority], exclusive)
+		}
+		for j, i := range indexes[priority][n:] {
+			msg := vals[priority][n+uint64(j)].(*QueueMsg)
+			if exclusive {
+				errs[i] = this.putReserved(msg)
+			} else {
+				errs[i] = this.enqueue(msg)
+			}
+			if errs[i] != nil {
+				atomic.AddUint64(&this.stats.putFailed, 1)
+			}
+		}
+	}
+	return errs
+}
+
+// Put the message of a locked object, it's spilled for OVERFLOW_SPILL or to keep the order.
+func (this *RpcObject) putReserved(msg *QueueMsg) error {
+	if this.spillMsg(msg, OverflowPolicy(atomic.LoadInt32(&this.overflow.policy)) == OVERFLOW_SPILL) {
+		return nil
+	}
+	if ok, quantity := this.lane(msg.Priority).Put(msg); !ok {
+		return queueFullError(quantity)
+	}
+	atomic.AddUint64(&this.stats.enqueued, 1)
+	this.wake()
+	return nil
+}
+
+// Put the values to the lane until it's full, it returns the number of values put.
+func (this *RpcObject) puts(priority Priority, vals []interface{}, locked bool) (done uint64) {
+	q := this.lane(priority)
+	if !locked {
+		this.sendLock.RLock()
+		defer this.sendLock.RUnlock()
+	}
+	for done < uint64(len(vals)) {
+		n, quantity := q.Puts(vals[done:])
+		done += n
+		if n == 0 && quantity+2 >= q.Capacity() {
+			break
+		}
+	}
+	if done > 0 {
+		atomic.AddUint64(&this.stats.enqueued, done)
+		this.wake()
+	}
+	return
+}
+
+// Append the jobs to the group without waiting, it returns the number of jobs queued and a *BatchError
+// for the failed ones. The jobs beyond the space of the queue fail with ErrQueueFull, and the jobs delayed
+// or dropped by limits are counted as queued. No job is queued if any of an all-or-nothing batch fails.
+func (this *Post) PutJobBatch(group string, jobs []BatchJob, allOrNothing bool) (int, error) {
+	msgs := make([]*QueueMsg, len(jobs))
+	for i, job := range jobs {
+		msgs[i] = newJobMsg(job.Func, nil, job.Params, nil, false)
+		msgs[i].Ctx = job.Ctx
+	}
+	for {
+		worker, err := getJobWorker(group)
+		if err != nil {
+			return 0, err
+		}
+		queued, err := worker.appendJobs(msgs, allOrNothing)
+		if err != ErrClosed || atomic.LoadInt32(&worker.reaped) == 0 {
+			return queued, err
+		}
+	}
+}
+
+func (this *JobWorker) appendJobs(msgs []*QueueMsg, allOrNothing bool) (queued int, err error) {
+	// the other senders are excluded for an all-or-nothing batch, so the space is reserved.
+	if allOrNothing {
+		this.lock.Lock()
+		defer this.lock.Unlock()
+	} else {
+		this.lock.RLock()
+		defer this.lock.RUnlock()
+	}
+	if this.closed {
+		atomic.AddUint64(&this.stats.putFailed, uint64(len(msgs)))
+		return 0, ErrClosed
+	}
+	if free := cap(this.jobQueue) - len(this.jobQueue); allOrNothing && free < len(msgs) {
+		atomic.AddUint64(&this.stats.putFailed, uint64(len(msgs)))
+		return 0, queueFullError(uint64(len(this.jobQueue)))
+	}
+
+	batch := &BatchError{}
+	admitted := make([]int, 0, len(msgs))
+	for i := range msgs {
+		msg := msgs[i]
+		if ok, err := this.limit(msg); err != nil {
+			batch.add(i, err)
+		} else if ok {
+			admitted = append(admitted, i)
+		} else {
+			queued++
+		}
+	}
+	if allOrNothing && len(batch.Errors) > 0 {
+		for _, i := range admitted {
+			msgs[i].release()
+		}
+		return 0, batch.abort(len(msgs))
+	}
+
+	sent := 0
+	for _, i := range admitted {
+		msg := msgs[i]
+		msg.trace()
+		select {
+		case this.jobQueue <- msg:
+			sent++
+		default:
+			msg.release()
+			atomic.AddUint64(&this.stats.putFailed, 1)
+			batch.add(i, queueFullError(uint64(len(this.jobQueue))))
+		}
+	}
+	if sent > 0 {
+		atomic.AddUint64(&this.stats.enqueued, uint64(sent))
+		atomic.StoreInt64(&this.active, time.Now().UnixNano())
+		if len(this.jobQueue) > 0 && this.config.MaxWorkers > this.config.Workers {
+			this.grow()
+		}
+	}
+	return queued + sent, batch.err()
+}
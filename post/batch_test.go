@@ -0,0 +1,173 @@
+package post
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Block the only object of the post until the returned channel is closed.
+func blockPost(p *Post) chan struct{} {
+	started, block := make(chan struct{}), make(chan struct{})
+	p.PutQueue(func() {
+		close(started)
+		<-block
+	})
+	<-started
+	return block
+}
+
+func countJobs(counter *int32, num int) []BatchJob {
+	jobs := make([]BatchJob, num)
+	for i := range jobs {
+		jobs[i] = BatchJob{Func: func() { atomic.AddInt32(counter, 1) }}
+	}
+	return jobs
+}
+
+func TestPutQueueBatch(t *testing.T) {
+	var counter int32
+	p := NewPost(uint64(16), 1)
+	defer p.Close()
+	block := blockPost(p)
+	queued, err := p.PutQueueBatch(countJobs(&counter, 20), false)
+	assert.True(t, queued >= 14 && queued < 20)
+	assert.True(t, errors.Is(err, ErrQueueFull))
+	var batch *BatchError
+	assert.True(t, errors.As(err, &batch))
+	assert.Equal(t, 20-queued, len(batch.Errors))
+	assert.Equal(t, queued, batch.Indexes()[0])
+	close(block)
+	time.Sleep(2 * MAX_SLEEP_TIME)
+	assert.Equal(t, int32(queued), atomic.LoadInt32(&counter))
+
+	// nothing is queued for an all-or-nothing batch.
+	counter = 0
+	block = blockPost(p)
+	queued, err = p.PutQueueBatch(countJobs(&counter, 20), true)
+	assert.Equal(t, 0, queued)
+	assert.True(t, errors.Is(err, ErrQueueFull))
+	p.Register("add", func(a, b int) int { return a + b })
+	jobs := countJobs(&counter, 3)
+	jobs[1] = BatchJob{Func: "add", Params: []interface{}{1}}
+	queued, err = p.PutQueueBatch(jobs, true)
+	assert.Equal(t, 0, queued)
+	assert.True(t, errors.As(err, &batch))
+	assert.True(t, errors.Is(batch.Errors[1], ErrBadParams))
+	assert.Equal(t, ErrBatchAborted, batch.Errors[0])
+	assert.Equal(t, ErrBatchAborted, batch.Errors[2])
+	close(block)
+	time.Sleep(2 * MAX_SLEEP_TIME)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&counter))
+
+	// the jobs are all queued if the overflow policy is OVERFLOW_SPILL.
+	p.SetOverflow(OVERFLOW_SPILL, 0)
+	block = blockPost(p)
+	queued, err = p.PutQueueBatch(countJobs(&counter, 20), true)
+	assert.Equal(t, 20, queued)
+	assert.Nil(t, err)
+	close(block)
+	time.Sleep(2 * MAX_SLEEP_TIME)
+	assert.Equal(t, int32(20), atomic.LoadInt32(&counter))
+}
+
+func TestPutQueueBatchAtomic(t *testing.T) {
+	var counter, slow int32
+	p := NewPost(uint64(64), 1)
+	defer p.Close()
+	// the job delayed by the limit is left untouched when the batch is aborted.
+	p.Register("slow", func() { atomic.AddInt32(&slow, 1) })
+	p.Register("add", func(a, b int) int { return a + b })
+	p.SetLimit("slow", &Limit{Rate: 20})
+	p.PutQueue("slow")
+	queued, err := p.PutQueueBatch([]BatchJob{{Func: "slow"}, {Func: "add", Params: []interface{}{1}}}, true)
+	assert.Equal(t, 0, queued)
+	assert.True(t, errors.Is(err, ErrBadParams))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&slow))
+	queued, err = p.PutQueueBatch([]BatchJob{{Func: "slow"}, {Func: "add", Params: []interface{}{1, 2}}}, true)
+	assert.Equal(t, 2, queued)
+	assert.Nil(t, err)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&slow))
+
+	// the space checked for a batch isn't taken by the other senders.
+	block := blockPost(p)
+	var total int32
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if p.PutQueue(func() { atomic.AddInt32(&counter, 1) }) == nil {
+					atomic.AddInt32(&total, 1)
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 4; j++ {
+				queued, err := p.PutQueueBatch(countJobs(&counter, 4), true)
+				if err == nil {
+					assert.Equal(t, 4, queued)
+				} else {
+					assert.Equal(t, 0, queued)
+					assert.True(t, errors.Is(err, ErrQueueFull))
+				}
+				atomic.AddInt32(&total, int32(queued))
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(0), atomic.LoadInt64(&p.objects[0].overflow.spilled))
+	close(block)
+	time.Sleep(2 * MAX_SLEEP_TIME)
+	assert.Equal(t, atomic.LoadInt32(&total), atomic.LoadInt32(&counter))
+}
+
+func TestPutQueueBatchSpread(t *testing.T) {
+	var counter int32
+	p := NewPost(uint64(64), 3)
+	defer p.Close()
+	jobs := countJobs(&counter, 30)
+	jobs[0].Priority = PRIORITY_HIGH
+	queued, err := p.PutQueueBatch(jobs, false)
+	assert.Equal(t, 30, queued)
+	assert.Nil(t, err)
+	time.Sleep(2 * MAX_SLEEP_TIME)
+	assert.Equal(t, int32(30), atomic.LoadInt32(&counter))
+	for _, stats := range p.Stats().Objects {
+		assert.Equal(t, uint64(10), stats.Enqueued)
+	}
+}
+
+func TestPutJobBatch(t *testing.T) {
+	var counter int32
+	p := NewPost(uint64(64), 1)
+	defer p.Close()
+	assert.Nil(t, CreateGroup("batch", GroupConfig{BufferSize: 4}))
+	defer CloseGroup("batch")
+	started, block := make(chan struct{}), make(chan struct{})
+	p.PutJob("batch", func() {
+		close(started)
+		<-block
+	})
+	<-started
+
+	queued, err := p.PutJobBatch("batch", countJobs(&counter, 6), true)
+	assert.Equal(t, 0, queued)
+	assert.True(t, errors.Is(err, ErrQueueFull))
+	queued, err = p.PutJobBatch("batch", countJobs(&counter, 6), false)
+	assert.Equal(t, 4, queued)
+	var batch *BatchError
+	assert.True(t, errors.As(err, &batch))
+	assert.Equal(t, []int{4, 5}, batch.Indexes())
+	close(block)
+	time.Sleep(2 * MAX_SLEEP_TIME)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&counter))
+}
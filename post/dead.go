@@ -0,0 +1,376 @@
+// Dead letters of the jobs which failed finally, they can be replayed after the bug is fixed.
+package post
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/TianQinS/fastapi/basic"
+)
+
+const (
+	// the default maximum dead letters kept in memory.
+	DEAD_LETTER_CAPACITY = 1024
+)
+
+var (
+	ErrDeadLetterNotFound = errors.New("post: dead letter not found")
+	// the dead letters of job worker groups.
+	groupDeadLetters sync.Map
+)
+
+type DeadLetter struct {
+	ID uint64
+	// the job worker group, it's empty for the jobs of objects.
+	Group   string
+	Msg     QueueMsg
+	Err     error
+	Stack   string
+	Attempt int
+	Time    time.Time
+	// the job was put to the spec object, so it's replayed there.
+	Spec bool
+	// the letter is kept in place while it's being replayed.
+	replaying bool
+}
+
+// The record of the file-backed store.
+type deadRecord struct {
+	Op      string        `json:"op"`
+	ID      uint64        `json:"id"`
+	Group   string        `json:"group,omitempty"`
+	Func    string        `json:"func,omitempty"`
+	Params  []interface{} `json:"params,omitempty"`
+	Err     string        `json:"err,omitempty"`
+	Stack   string        `json:"stack,omitempty"`
+	Attempt int           `json:"attempt,omitempty"`
+	Time    time.Time     `json:"time"`
+	Spec    bool          `json:"spec,omitempty"`
+}
+
+// A bounded store of dead letters, the oldest one is discarded when it's full.
+type DeadLetters struct {
+	lock     sync.Mutex
+	letters  []*DeadLetter
+	capacity int
+	seq      uint64
+	// the post for replaying the jobs of objects.
+	post *Post
+	// the optional audit trail in JSON lines.
+	file *os.File
+}
+
+func NewDeadLetters(capacity int) *DeadLetters {
+	if capacity <= 0 {
+		capacity = DEAD_LETTER_CAPACITY
+	}
+	return &DeadLetters{
+		letters:  make([]*DeadLetter, 0),
+		capacity: capacity,
+	}
+}
+
+// The dead letters are also appended to the file as an audit trail, with the operations on them.
+// The letters in the file are loaded, their functions are restored by name and the params are decoded
+// from JSON, so only the named calls with JSON-compatible params can be replayed after restart.
+func NewFileDeadLetters(filePath string, capacity int) (*DeadLetters, error) {
+	d := NewDeadLetters(capacity)
+	if err := d.load(filePath); err != nil {
+		return nil, err
+	}
+	f, err := basic.NewFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	d.file = f
+	return d, nil
+}
+
+// Load the letters which are not removed from the file.
+func (this *DeadLetters) load(filePath string) error {
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, line := range bytes.Split(data, []byte{'\n'}) {
+		if len(line) == 0 {
+			continue
+		}
+		var record deadRecord
+		if err = json.Unmarshal(line, &record); err != nil {
+			log.Printf("[DeadLetters] %s: %v\n", filePath, err)
+			continue
+		}
+		if record.ID > this.seq {
+			this.seq = record.ID
+		}
+		if record.Op != "add" {
+			this.drop(record.ID)
+			continue
+		}
+		if len(this.letters) >= this.capacity {
+			this.letters = this.letters[1:]
+		}
+		this.letters = append(this.letters, &DeadLetter{
+			ID:      record.ID,
+			Group:   record.Group,
+			Msg:     QueueMsg{Func: record.Func, Params: record.Params},
+			Err:     errors.New(record.Err),
+			Stack:   record.Stack,
+			Attempt: record.Attempt,
+			Time:    record.Time,
+			Spec:    record.Spec,
+		})
+	}
+	return nil
+}
+
+// The name of the function for the audit trail.
+func funcName(f interface{}) string {
+	switch f.(type) {
+	case string:
+		return f.(string)
+	case nil:
+		return ""
+	default:
+		return runtime.FuncForPC(reflect.ValueOf(f).Pointer()).Name()
+	}
+}
+
+// Append a record to the file, it must be called with the lock.
+func (this *DeadLetters) write(op string, letter *DeadLetter) {
+	if this.file == nil {
+		return
+	}
+	record := deadRecord{Op: op, ID: letter.ID, Time: time.Now()}
+	if op == "add" {
+		record.Group = letter.Group
+		record.Func = funcName(letter.Msg.Func)
+		record.Params = letter.Msg.Params
+		record.Err = letter.Err.Error()
+		record.Stack = letter.Stack
+		record.Attempt = letter.Attempt
+		record.Time = letter.Time
+		record.Spec = letter.Spec
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		// the parameters can't be encoded.
+		record.Params = []interface{}{fmt.Sprintf("%+v", record.Params)}
+		data, _ = json.Marshal(record)
+	}
+	this.file.Write(append(data, '\n'))
+}
+
+func (this *DeadLetters) add(letter *DeadLetter) {
+	defer this.lock.Unlock()
+	this.lock.Lock()
+	this.seq++
+	letter.ID = this.seq
+	if len(this.letters) >= this.capacity {
+		this.letters = this.letters[1:]
+	}
+	this.letters = append(this.letters, letter)
+	this.write("add", letter)
+}
+
+func (this *DeadLetters) Len() int {
+	defer this.lock.Unlock()
+	this.lock.Lock()
+	return len(this.letters)
+}
+
+// List the dead letters from the oldest.
+func (this *DeadLetters) List() []*DeadLetter {
+	defer this.lock.Unlock()
+	this.lock.Lock()
+	letters := make([]*DeadLetter, len(this.letters))
+	copy(letters, this.letters)
+	return letters
+}
+
+func (this *DeadLetters) Get(id uint64) (*DeadLetter, bool) {
+	defer this.lock.Unlock()
+	this.lock.Lock()
+	for _, letter := range this.letters {
+		if letter.ID == id {
+			return letter, true
+		}
+	}
+	return nil, false
+}
+
+func (this *DeadLetters) remove(id uint64, op string) *DeadLetter {
+	defer this.lock.Unlock()
+	this.lock.Lock()
+	letter := this.drop(id)
+	if letter != nil {
+		this.write(op, letter)
+	}
+	return letter
+}
+
+// Remove the letter from memory, it must be called with the lock.
+func (this *DeadLetters) drop(id uint64) *DeadLetter {
+	for i, letter := range this.letters {
+		if letter.ID == id {
+			this.letters = append(this.letters[:i], this.letters[i+1:]...)
+			return letter
+		}
+	}
+	return nil
+}
+
+// Mark the letter as being replayed, nil if it's not found or being replayed.
+func (this *DeadLetters) take(id uint64) *DeadLetter {
+	defer this.lock.Unlock()
+	this.lock.Lock()
+	for _, letter := range this.letters {
+		if letter.ID == id && !letter.replaying {
+			letter.replaying = true
+			return letter
+		}
+	}
+	return nil
+}
+
+func (this *DeadLetters) Remove(id uint64) bool {
+	return this.remove(id, "remove") != nil
+}
+
+// Remove all the dead letters, it returns the number of them.
+func (this *DeadLetters) Purge() int {
+	defer this.lock.Unlock()
+	this.lock.Lock()
+	n := len(this.letters)
+	for _, letter := range this.letters {
+		this.write("purge", letter)
+	}
+	this.letters = this.letters[:0]
+	return n
+}
+
+// Put the job again with a fresh attempt count to the group, the spec object or the objects it was put to,
+// the letter is removed if it's put successfully, or it's kept in place for the next replay.
+func (this *DeadLetters) Replay(id uint64) error {
+	letter := this.take(id)
+	if letter == nil {
+		return ErrDeadLetterNotFound
+	}
+	msg := letter.Msg
+	msg.Future = nil
+	msg.Attempt = 0
+	// the context of the failed job is usually done.
+	msg.Ctx = nil
+	msg.Trace = nil
+	var err error
+	switch {
+	case letter.Group != "":
+		err = appendGroupJob(nil, letter.Group, &msg)
+	case this.post == nil:
+		err = ErrNoWorkers
+	case letter.Spec:
+		err = this.post.Object.putMsg(&msg)
+	default:
+		err = this.post.requeue(&msg)
+	}
+	if err == nil {
+		this.remove(id, "replay")
+		return nil
+	}
+	this.lock.Lock()
+	letter.replaying = false
+	this.lock.Unlock()
+	return err
+}
+
+// Replay all the dead letters, it stops at the first error.
+func (this *DeadLetters) ReplayAll() (n int, err error) {
+	for _, letter := range this.List() {
+		if err = this.Replay(letter.ID); err != nil {
+			return
+		}
+		n++
+	}
+	return
+}
+
+// Close the file of the store.
+func (this *DeadLetters) Close() error {
+	defer this.lock.Unlock()
+	this.lock.Lock()
+	if this.file == nil {
+		return nil
+	}
+	err := this.file.Close()
+	this.file = nil
+	return err
+}
+
+// Make a dead letter of the failed job.
+func newDeadLetter(msg *QueueMsg, err error, stack string) *DeadLetter {
+	letter := &DeadLetter{
+		Msg:     msg.clone(),
+		Err:     err,
+		Stack:   stack,
+		Attempt: msg.Attempt + 1,
+		Time:    time.Now(),
+	}
+	letter.Msg.requeue = nil
+	letter.Msg.journal = nil
+	letter.Msg.limiter = nil
+	return letter
+}
+
+// Set the dead letters for the jobs of all objects, the failed jobs are only logged if it's nil.
+func (this *Post) SetDeadLetters(dead *DeadLetters) {
+	defer this.lock.Unlock()
+	this.lock.Lock()
+	if dead != nil && dead.post == nil {
+		dead.post = this
+	}
+	this.dead = dead
+	for _, o := range this.objects {
+		o.dead.Store(dead)
+	}
+	this.Object.dead.Store(dead)
+}
+
+func (this *Post) DeadLetters() *DeadLetters {
+	defer this.lock.Unlock()
+	this.lock.Lock()
+	return this.dead
+}
+
+// Set the dead letters for the jobs of the group.
+func (this *Post) SetGroupDeadLetters(group string, dead *DeadLetters) {
+	if dead == nil {
+		groupDeadLetters.Delete(group)
+		return
+	}
+	groupDeadLetters.Store(group, dead)
+}
+
+func (this *RpcObject) deadLetter(letter *DeadLetter) {
+	if dead := this.dead.Load(); dead != nil {
+		letter.Spec = this.spec
+		dead.add(letter)
+	}
+}
+
+func (this *JobWorker) deadLetter(letter *DeadLetter) {
+	if dead, ok := groupDeadLetters.Load(this.group); ok {
+		letter.Group = this.group
+		dead.(*DeadLetters).add(letter)
+	}
+}
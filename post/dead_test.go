@@ -0,0 +1,185 @@
+package post
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errBug = errors.New("bug")
+
+func TestDeadLetters(t *testing.T) {
+	var fixed, calls int32
+	reward := func(n int) {
+		if atomic.LoadInt32(&fixed) == 0 {
+			panic(errBug)
+		}
+		atomic.AddInt32(&calls, int32(n))
+	}
+	p := NewPost(uint64(64), 1)
+	dead := NewDeadLetters(2)
+	p.SetDeadLetters(dead)
+	assert.Equal(t, dead, p.DeadLetters())
+	p.Register("reward", reward)
+
+	p.PutQueue("missing")
+	p.PutQueue(reward, 1)
+	p.PutQueue("reward", 2)
+	time.Sleep(5 * MAX_SLEEP_TIME)
+	// the oldest one is discarded.
+	letters := dead.List()
+	assert.Equal(t, 2, len(letters))
+	assert.Equal(t, errBug, letters[0].Err)
+	assert.Equal(t, 1, letters[0].Attempt)
+	assert.Equal(t, []interface{}{1}, letters[0].Msg.Params)
+	assert.True(t, strings.Contains(letters[0].Stack, "TestDeadLetters"))
+	letter, ok := dead.Get(letters[1].ID)
+	assert.True(t, ok)
+	assert.Equal(t, "reward", letter.Msg.Func)
+
+	atomic.StoreInt32(&fixed, 1)
+	n, err := dead.ReplayAll()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	time.Sleep(5 * MAX_SLEEP_TIME)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+	assert.Equal(t, 0, dead.Len())
+	assert.Equal(t, ErrDeadLetterNotFound, dead.Replay(letter.ID))
+	p.Close()
+}
+
+func TestGroupDeadLetters(t *testing.T) {
+	dead := NewDeadLetters(0)
+	GPost.SetGroupDeadLetters("dead", dead)
+	defer GPost.SetGroupDeadLetters("dead", nil)
+	GPost.PutJob("dead", func() { panic("bad") })
+	time.Sleep(5 * MAX_SLEEP_TIME)
+	letters := dead.List()
+	assert.Equal(t, 1, len(letters))
+	assert.Equal(t, "dead", letters[0].Group)
+	assert.False(t, dead.Remove(0))
+	assert.True(t, dead.Remove(letters[0].ID))
+
+	GPost.PutJob("dead", func() { panic("bad") })
+	time.Sleep(5 * MAX_SLEEP_TIME)
+	assert.Equal(t, 1, dead.Purge())
+	assert.Equal(t, 0, dead.Len())
+}
+
+func TestFileDeadLetters(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "dead")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dead.log")
+	dead, err := NewFileDeadLetters(path, 0)
+	assert.Nil(t, err)
+
+	p := NewPost(uint64(64), 1)
+	p.SetDeadLetters(dead)
+	p.PutQueue(func(n int) { panic(errBug) }, 1)
+	time.Sleep(5 * MAX_SLEEP_TIME)
+	dead.Purge()
+	assert.Nil(t, dead.Close())
+	p.Close()
+
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	assert.Equal(t, 2, len(lines))
+	assert.True(t, strings.Contains(lines[0], `"op":"add"`))
+	assert.True(t, strings.Contains(lines[0], `"params":[1]`))
+	assert.True(t, strings.Contains(lines[1], `"op":"purge"`))
+}
+
+func TestReloadDeadLetters(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "dead")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dead.log")
+	dead, err := NewFileDeadLetters(path, 0)
+	assert.Nil(t, err)
+
+	var fixed int32
+	names := make(chan string, 4)
+	greet := func(name string) {
+		if atomic.LoadInt32(&fixed) == 0 {
+			panic(errBug)
+		}
+		names <- name
+	}
+	p := NewPost(uint64(64), 1)
+	p.SetDeadLetters(dead)
+	p.Register("greet", greet)
+	p.PutQueue("greet", "a")
+	p.PutQueue("greet", "b")
+	time.Sleep(5 * MAX_SLEEP_TIME)
+	p.PutQueueSpec("greet", "spec")
+	time.Sleep(5 * MAX_SLEEP_TIME)
+	letters := dead.List()
+	assert.Equal(t, 3, len(letters))
+	assert.True(t, dead.Remove(letters[0].ID))
+	assert.Nil(t, dead.Close())
+	p.Close()
+
+	// the removed letter is not loaded, and the new letters follow the stored ones.
+	reloaded, err := NewFileDeadLetters(path, 0)
+	assert.Nil(t, err)
+	defer reloaded.Close()
+	stored := reloaded.List()
+	assert.Equal(t, 2, len(stored))
+	assert.Equal(t, letters[1].ID, stored[0].ID)
+	assert.Equal(t, []interface{}{"b"}, stored[0].Msg.Params)
+	assert.Equal(t, errBug.Error(), stored[0].Err.Error())
+	assert.False(t, stored[0].Spec)
+	assert.True(t, stored[1].Spec)
+
+	// the failed replay leaves the letter in place.
+	assert.Equal(t, ErrNoWorkers, reloaded.Replay(stored[0].ID))
+	assert.Equal(t, stored[0].ID, reloaded.List()[0].ID)
+
+	p = NewPost(uint64(64), 1)
+	defer p.Close()
+	p.SetDeadLetters(reloaded)
+	p.Register("greet", greet)
+	atomic.StoreInt32(&fixed, 1)
+	n, err := reloaded.ReplayAll()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	time.Sleep(5 * MAX_SLEEP_TIME)
+	assert.Equal(t, 0, reloaded.Len())
+	assert.ElementsMatch(t, []string{"b", "spec"}, []string{<-names, <-names})
+	assert.Equal(t, uint64(1), p.Object.Stats().Executed)
+}
+
+func TestReplayStaleCtx(t *testing.T) {
+	var fixed int32
+	done := make(chan struct{}, 1)
+	p := NewPost(uint64(64), 1)
+	defer p.Close()
+	dead := NewDeadLetters(0)
+	p.SetDeadLetters(dead)
+	ctx, cancel := context.WithCancel(context.Background())
+	p.PutQueueCtx(ctx, func() {
+		if atomic.LoadInt32(&fixed) == 0 {
+			panic(errBug)
+		}
+		done <- struct{}{}
+	})
+	time.Sleep(5 * MAX_SLEEP_TIME)
+	cancel()
+	letters := dead.List()
+	assert.Equal(t, 1, len(letters))
+	atomic.StoreInt32(&fixed, 1)
+	assert.Nil(t, dead.Replay(letters[0].ID))
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the replayed job isn't executed")
+	}
+}
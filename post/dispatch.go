@@ -0,0 +1,229 @@
+// Strategies to dispatch jobs to the running objects of Post.
+package post
+
+import (
+	"context"
+	"hash/fnv"
+	"math/rand"
+	"reflect"
+	"sync/atomic"
+)
+
+// Dispatcher selects one of the running objects for a job, the key is the hash of the job's function.
+type Dispatcher interface {
+	Pick(objects []*RpcObject, key uint64) int
+}
+
+type roundRobin struct {
+	seq uint64
+}
+
+type leastQueued struct {
+	seq uint64
+}
+
+type powerOfTwo struct{}
+
+type funcAffinity struct{}
+
+// Select objects in turn, the default strategy.
+func RoundRobin() Dispatcher {
+	return &roundRobin{}
+}
+
+// Select the object with the least jobs waiting.
+func LeastQueued() Dispatcher {
+	return &leastQueued{}
+}
+
+// Select the less loaded one of two random objects.
+func PowerOfTwo() Dispatcher {
+	return &powerOfTwo{}
+}
+
+// Jobs of the same function are always dispatched to the same object while the pool size is unchanged,
+// it keeps the state of a function in one object, but a hot function overloads its object.
+// Use PutQueueKeyed to route the jobs by a key of the caller instead.
+func FuncAffinity() Dispatcher {
+	return &funcAffinity{}
+}
+
+func (this *roundRobin) Pick(objects []*RpcObject, key uint64) int {
+	return int(atomic.AddUint64(&this.seq, 1) % uint64(len(objects)))
+}
+
+func (this *leastQueued) Pick(objects []*RpcObject, key uint64) int {
+	size := len(objects)
+	// start from different objects to break ties.
+	start := int(atomic.AddUint64(&this.seq, 1) % uint64(size))
+	best, min := start, objects[start].depth()
+	for i := 1; i < size && min > 0; i++ {
+		j := (start + i) % size
+		if depth := objects[j].depth(); depth < min {
+			best, min = j, depth
+		}
+	}
+	return best
+}
+
+func (this *powerOfTwo) Pick(objects []*RpcObject, key uint64) int {
+	size := len(objects)
+	if size == 1 {
+		return 0
+	}
+	i, j := rand.Intn(size), rand.Intn(size-1)
+	if j >= i {
+		j++
+	}
+	if objects[j].depth() < objects[i].depth() {
+		return j
+	}
+	return i
+}
+
+func (this *funcAffinity) Pick(objects []*RpcObject, key uint64) int {
+	return int(key % uint64(len(objects)))
+}
+
+// The number of jobs waiting in the object, jobs of all priorities are counted.
+func (this *RpcObject) depth() uint64 {
+	return this.quantity() + uint64(atomic.LoadInt64(&this.overflow.spilled)+atomic.LoadInt64(&this.batched))
+}
+
+// Hash the function name or the function pointer.
+func funcKey(f interface{}) uint64 {
+	switch f.(type) {
+	case string:
+		h := fnv.New64a()
+		h.Write([]byte(f.(string)))
+		return h.Sum64()
+	case nil:
+		return 0
+	}
+	if v := reflect.ValueOf(f); v.Kind() == reflect.Func {
+		return uint64(v.Pointer())
+	}
+	return 0
+}
+
+// Select a running object for the function, nil will be returned if no object is running.
+func (this *Post) pick(f interface{}) *RpcObject {
+	objects := this.runningObjects()
+	switch len(objects) {
+	case 0:
+		return nil
+	case 1:
+		return objects[0]
+	}
+	i := this.dispatcher.Pick(objects, funcKey(f))
+	// skip the objects whose job is stuck, unless all of them are.
+	for n := 0; n < len(objects); n++ {
+		if o := objects[(i+n)%len(objects)]; !o.current.isStuck() {
+			return o
+		}
+	}
+	return objects[i]
+}
+
+func (this *Post) runningObjects() []*RpcObject {
+	objects, _ := this.running.Load().([]*RpcObject)
+	return objects
+}
+
+// Refresh the snapshot of running objects, it must be called with the lock.
+func (this *Post) refresh() {
+	objects := make([]*RpcObject, this.index)
+	copy(objects, this.objects[:this.index])
+	this.running.Store(objects)
+}
+
+// Apply the checks, limits and policies to the message before it's queued,
+// ok is false if the job isn't put now, and journaled is true if it's appended to the journal.
+func (this *Post) admit(o *RpcObject, msg *QueueMsg) (ok, journaled bool, err error) {
+	ok, journaled, settle, err := this.deferAdmit(o, msg)
+	if settle != nil {
+		settle()
+	}
+	return
+}
+
+// Check the message like admit, the job dropped or delayed by limits is settled by the caller.
+func (this *Post) deferAdmit(o *RpcObject, msg *QueueMsg) (ok, journaled bool, settle func(), err error) {
+	// named calls with bad parameters are rejected before queued.
+	if err = this.Functions.checkMsg(msg); err != nil {
+		atomic.AddUint64(&o.stats.putFailed, 1)
+		return
+	}
+	if ok, settle, err = this.deferLimit(o, msg); !ok {
+		return
+	}
+	this.retryPolicy(msg)
+	this.jobTimeout(msg)
+	if msg.Retry != nil && msg.requeue == nil {
+		msg.requeue = this.requeue
+	}
+	return true, this.journalMsg(msg), nil, nil
+}
+
+// Dispatch the message to a running object, it's retried if the object is retired meanwhile.
+func (this *Post) putMsg(o *RpcObject, msg *QueueMsg) (err error) {
+	ok, journaled, err := this.admit(o, msg)
+	if !ok {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			msg.release()
+			if journaled {
+				msg.complete()
+			}
+		}
+	}()
+	return this.send(o, msg)
+}
+
+// Put the admitted message to the object, another one is picked if it's retired.
+func (this *Post) send(o *RpcObject, msg *QueueMsg) (err error) {
+	for retry := 0; ; retry++ {
+		if err = o.putMsg(msg); err != ErrClosed || atomic.LoadInt32(&this.closed) == 1 || retry > len(this.runningObjects()) {
+			return
+		}
+		if o = this.pick(msg.Func); o == nil {
+			return ErrNoWorkers
+		}
+	}
+}
+
+// Put a job to a running object selected by the dispatcher.
+func (this *Post) put(ctx context.Context, priority Priority, f, cb interface{}, strictUnReflect bool, cbParams, params []interface{}) error {
+	o := this.pick(f)
+	if o == nil {
+		return this.noWorkersError()
+	}
+	msg := o.newMsg(f, cb, params, cbParams, strictUnReflect)
+	msg.Ctx = ctx
+	msg.Priority = priority
+	return this.putMsg(o, msg)
+}
+
+func (this *Post) submit(ctx context.Context, f interface{}, params []interface{}) *Future {
+	o := this.pick(f)
+	if o == nil {
+		return rejectedFuture(this.noWorkersError())
+	}
+	future := NewFuture()
+	msg := o.newMsg(f, nil, params, nil, false)
+	msg.Ctx = ctx
+	msg.Future = future
+	if err := this.putMsg(o, msg); err != nil {
+		future.resolve(nil, err)
+	}
+	return future
+}
+
+func (this *Post) noWorkersError() error {
+	if atomic.LoadInt32(&this.closed) == 1 {
+		return ErrClosed
+	}
+	return ErrNoWorkers
+}
@@ -0,0 +1,48 @@
+package post
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newDispatchObjects(depths ...int) []*RpcObject {
+	objects := make([]*RpcObject, len(depths))
+	for i, depth := range depths {
+		objects[i] = newOverflowObject(OVERFLOW_FAIL, 0)
+		for j := 0; j < depth; j++ {
+			objects[i].PutQueue(func() {}, false)
+		}
+	}
+	return objects
+}
+
+func TestDispatcher(t *testing.T) {
+	objects := newDispatchObjects(3, 1, 2)
+	rr := RoundRobin()
+	seq := []int{rr.Pick(objects, 0), rr.Pick(objects, 0), rr.Pick(objects, 0)}
+	assert.ElementsMatch(t, []int{0, 1, 2}, seq)
+
+	lq := LeastQueued()
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, 1, lq.Pick(objects, 0))
+	}
+	p2c := PowerOfTwo()
+	for i := 0; i < 10; i++ {
+		assert.NotEqual(t, 0, p2c.Pick(objects, 0))
+	}
+	fa := FuncAffinity()
+	key := funcKey("test1")
+	assert.Equal(t, fa.Pick(objects, key), fa.Pick(objects, funcKey("test1")))
+	assert.Equal(t, funcKey(func1), funcKey(func1))
+}
+
+func TestPostDispatcher(t *testing.T) {
+	p := NewPost(uint64(1024), 3, WithDispatcher(FuncAffinity()))
+	o := p.pick("test1")
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, o, p.pick("test1"))
+	}
+	p.Close()
+	assert.Equal(t, ErrClosed, p.PutQueue("test1"))
+}
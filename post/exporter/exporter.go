@@ -0,0 +1,121 @@
+// Package exporter exports the runtime metrics of post in Prometheus text format or by expvar.
+package exporter
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/TianQinS/fastapi/post"
+)
+
+const (
+	METRICS_PATH = "/metrics"
+	EXPVAR_PATH  = "/debug/vars"
+)
+
+// The handler writes the metrics of the post in Prometheus text format.
+func Handler(p *post.Post) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		WriteText(w, p.Stats())
+	})
+}
+
+// Publish the metrics of the post as an expvar variable.
+func Publish(name string, p *post.Post) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return p.Stats()
+	}))
+}
+
+// Serve the metrics on a local address such as "127.0.0.1:9100" in a new goroutine,
+// the error of listening such as the address in use is returned.
+func Serve(addr string, p *post.Post) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(METRICS_PATH, Handler(p))
+	mux.Handle(EXPVAR_PATH, expvar.Handler())
+	server := &http.Server{Addr: listener.Addr().String(), Handler: mux}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("[Exporter] %v\n", err)
+		}
+	}()
+	return server, nil
+}
+
+type sample struct {
+	label string
+	stats post.Stats
+}
+
+// Write the metrics in Prometheus text format.
+func WriteText(w io.Writer, stats post.PostStats) {
+	samples := make([]sample, 0, len(stats.Objects)+len(stats.Groups)+1)
+	samples = append(samples, sample{`object="spec"`, stats.Spec})
+	for i, s := range stats.Objects {
+		samples = append(samples, sample{fmt.Sprintf(`object="%d"`, i), s})
+	}
+	groups := make([]string, 0, len(stats.Groups))
+	for group := range stats.Groups {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	for _, group := range groups {
+		samples = append(samples, sample{fmt.Sprintf(`group=%q`, group), stats.Groups[group]})
+	}
+
+	gauge := func(name, help string, value func(post.Stats) string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+		for _, s := range samples {
+			fmt.Fprintf(w, "%s{%s} %s\n", name, s.label, value(s.stats))
+		}
+	}
+	counter := func(name, help string, value func(post.Stats) uint64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for _, s := range samples {
+			fmt.Fprintf(w, "%s{%s} %d\n", name, s.label, value(s.stats))
+		}
+	}
+	gauge("post_queue_depth", "Jobs waiting in the queue.", func(s post.Stats) string {
+		return strconv.FormatUint(s.Depth, 10)
+	})
+	gauge("post_queue_capacity", "Capacity of the queue.", func(s post.Stats) string {
+		return strconv.FormatUint(s.Capacity, 10)
+	})
+	counter("post_jobs_enqueued_total", "Jobs put into the queue.", func(s post.Stats) uint64 { return s.Enqueued })
+	counter("post_jobs_executed_total", "Jobs executed.", func(s post.Stats) uint64 { return s.Executed })
+	counter("post_jobs_failed_total", "Jobs cancelled or whose function is not found.", func(s post.Stats) uint64 { return s.Failed })
+	counter("post_jobs_panicked_total", "Jobs panicked.", func(s post.Stats) uint64 { return s.Panicked })
+	counter("post_put_failed_total", "Jobs failed to put.", func(s post.Stats) uint64 { return s.PutFailed })
+	counter("post_jobs_dropped_total", "Jobs dropped by overflow or shutdown.", func(s post.Stats) uint64 { return s.Dropped })
+	counter("post_jobs_retried_total", "Failed attempts rescheduled by retry policies.", func(s post.Stats) uint64 { return s.Retried })
+	counter("post_jobs_stolen_total", "Jobs taken out of the queue by the idle siblings.", func(s post.Stats) uint64 { return s.Stolen })
+	fmt.Fprintf(w, "# HELP post_idle_seconds_total Idle time of the worker.\n# TYPE post_idle_seconds_total counter\n")
+	for _, s := range samples {
+		fmt.Fprintf(w, "post_idle_seconds_total{%s} %g\n", s.label, s.stats.IdleTime.Seconds())
+	}
+
+	name := "post_job_latency_seconds"
+	fmt.Fprintf(w, "# HELP %s Execution latency of jobs.\n# TYPE %s histogram\n", name, name)
+	for _, s := range samples {
+		h := s.stats.Latency
+		cumulative := uint64(0)
+		for i, bound := range h.Buckets {
+			cumulative += h.Counts[i]
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%g\"} %d\n", name, s.label, bound.Seconds(), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, s.label, h.Count)
+		fmt.Fprintf(w, "%s_sum{%s} %g\n", name, s.label, h.Sum.Seconds())
+		fmt.Fprintf(w, "%s_count{%s} %d\n", name, s.label, h.Count)
+	}
+}
@@ -0,0 +1,47 @@
+package exporter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TianQinS/fastapi/post"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler(t *testing.T) {
+	p := post.NewPost(uint64(1024), 1)
+	p.PutQueue(func() {})
+	p.PutQueue(func() { panic("bad") })
+	time.Sleep(15 * time.Millisecond)
+
+	stats := p.Stats()
+	assert.Equal(t, uint64(2), stats.Objects[0].Executed)
+	assert.Equal(t, uint64(1), stats.Objects[0].Panicked)
+
+	rec := httptest.NewRecorder()
+	Handler(p).ServeHTTP(rec, httptest.NewRequest("GET", METRICS_PATH, nil))
+	body := rec.Body.String()
+	assert.Equal(t, true, strings.Contains(body, `post_jobs_executed_total{object="0"} 2`))
+	assert.Equal(t, true, strings.Contains(body, `post_jobs_panicked_total{object="0"} 1`))
+	assert.Equal(t, true, strings.Contains(body, `post_job_latency_seconds_count{object="0"} 2`))
+	p.Close()
+}
+
+func TestServe(t *testing.T) {
+	p := post.NewPost(uint64(64), 1)
+	defer p.Close()
+	server, err := Serve("127.0.0.1:0", p)
+	assert.Nil(t, err)
+	defer server.Close()
+	resp, err := http.Get("http://" + server.Addr + METRICS_PATH)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// the address is in use.
+	_, err = Serve(server.Addr, p)
+	assert.NotNil(t, err)
+}
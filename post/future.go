@@ -0,0 +1,162 @@
+// Future for the results of asynchronous jobs.
+package post
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/TianQinS/fastapi/basic"
+)
+
+var (
+	ErrFutureTimeout = errors.New("post: future wait timeout")
+)
+
+// Future is resolved once with the return values of a job or the recovered panic as an error.
+type Future struct {
+	done chan struct{}
+	once sync.Once
+	rets []interface{}
+	err  error
+}
+
+func NewFuture() *Future {
+	return &Future{
+		done: make(chan struct{}),
+	}
+}
+
+// Create a future which has been resolved with the error.
+func rejectedFuture(err error) *Future {
+	future := NewFuture()
+	future.resolve(nil, err)
+	return future
+}
+
+// Only the first call takes effect.
+func (this *Future) resolve(rets []interface{}, err error) {
+	this.once.Do(func() {
+		this.rets = rets
+		this.err = err
+		close(this.done)
+	})
+}
+
+// The returned channel is closed when the future is resolved.
+func (this *Future) Done() <-chan struct{} {
+	return this.done
+}
+
+// Wait for the result, a non-positive timeout means waiting forever.
+func (this *Future) Wait(timeout time.Duration) ([]interface{}, error) {
+	if timeout <= 0 {
+		<-this.done
+		return this.rets, this.err
+	}
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-this.done:
+		return this.rets, this.err
+	case <-t.C:
+		return nil, ErrFutureTimeout
+	}
+}
+
+// Get the result without blocking, ok is false if the future is not resolved yet.
+func (this *Future) Result() (rets []interface{}, err error, ok bool) {
+	select {
+	case <-this.done:
+		return this.rets, this.err, true
+	default:
+		return nil, nil, false
+	}
+}
+
+// Call f with the return values when this future is resolved without error,
+// the error is passed through to the returned future directly.
+func (this *Future) Then(f interface{}) *Future {
+	future := NewFuture()
+	go func() {
+		<-this.done
+		if this.err != nil {
+			future.resolve(nil, this.err)
+			return
+		}
+		future.resolve(callFunc(f, this.rets))
+	}()
+	return future
+}
+
+// The returned future is resolved with the results of all futures in order,
+// or the first error of them.
+func All(futures ...*Future) *Future {
+	future := NewFuture()
+	go func() {
+		rets := make([]interface{}, len(futures))
+		for i, f := range futures {
+			res, err := f.Wait(0)
+			if err != nil {
+				future.resolve(nil, err)
+				return
+			}
+			rets[i] = res
+		}
+		future.resolve(rets, nil)
+	}()
+	return future
+}
+
+// The returned future is resolved with the first successful result,
+// or the last error if all of them failed.
+func Any(futures ...*Future) *Future {
+	future := NewFuture()
+	if len(futures) == 0 {
+		future.resolve(nil, errors.New("post: no future to wait"))
+		return future
+	}
+	var lock sync.Mutex
+	failed := 0
+	for _, f := range futures {
+		go func(f *Future) {
+			res, err := f.Wait(0)
+			if err == nil {
+				future.resolve(res, nil)
+				return
+			}
+			lock.Lock()
+			failed++
+			if failed == len(futures) {
+				future.resolve(nil, err)
+			}
+			lock.Unlock()
+		}(f)
+	}
+	return future
+}
+
+// Call the function by reflect with panic recovery.
+func callFunc(f interface{}, args []interface{}) (rets []interface{}, err error) {
+	defer func() {
+		if info := recover(); info != nil {
+			err = basic.PanicError(info)
+			basic.Report(_REPORT_SOURCE, err, map[string]interface{}{"func": funcName(f), "params": args})
+		}
+	}()
+	in := make([]reflect.Value, len(args))
+	for k := range in {
+		in[k] = reflect.ValueOf(args[k])
+	}
+	rets = valuesToInterfaces(reflect.ValueOf(f).Call(in))
+	return
+}
+
+func valuesToInterfaces(vals []reflect.Value) []interface{} {
+	rets := make([]interface{}, len(vals))
+	for k := range vals {
+		rets[k] = vals[k].Interface()
+	}
+	return rets
+}
@@ -0,0 +1,62 @@
+package post
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFuture(t *testing.T) {
+	p := NewPost(uint64(1024), 2)
+	f1 := p.Submit(func(a, b int) int {
+		return a + b
+	}, 1, 2)
+	rets, err := f1.Wait(time.Second)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []interface{}{3}, rets)
+
+	f2 := p.Submit(func() {
+		panic(errors.New("bad"))
+	})
+	_, err = f2.Wait(time.Second)
+	assert.Equal(t, "bad", err.Error())
+
+	f3 := f1.Then(func(sum int) (int, string) {
+		return sum * 2, "ok"
+	})
+	rets, err = f3.Wait(time.Second)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []interface{}{6, "ok"}, rets)
+
+	block := make(chan bool)
+	f4 := p.Submit(func() { <-block })
+	_, err = f4.Wait(10 * time.Millisecond)
+	assert.Equal(t, ErrFutureTimeout, err)
+	close(block)
+	p.Close()
+}
+
+func TestFutureCompose(t *testing.T) {
+	p := NewPost(uint64(1024), 2)
+	fs := []*Future{
+		p.Submit(func() int { return 1 }),
+		p.Submit(func() int { return 2 }),
+	}
+	rets, err := All(fs...).Wait(time.Second)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []interface{}{[]interface{}{1}, []interface{}{2}}, rets)
+
+	failed := p.Submit(func() { panic("bad") })
+	_, err = All(fs[0], failed).Wait(time.Second)
+	assert.NotEqual(t, nil, err)
+
+	rets, err = Any(failed, fs[1]).Wait(time.Second)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []interface{}{2}, rets)
+
+	_, err = Any(failed, p.Submit("missing")).Wait(time.Second)
+	assert.NotEqual(t, nil, err)
+	p.Close()
+}
@@ -0,0 +1,275 @@
+// Lifecycle of job worker groups.
+package post
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// the default idle time before an elastic goroutine exits.
+	GROUP_ELASTIC_IDLE = time.Second
+)
+
+var (
+	ErrGroupExists   = errors.New("post: group exists")
+	ErrGroupNotFound = errors.New("post: group not found")
+	// the config of the groups created by PutJob implicitly.
+	DefaultGroupConfig = GroupConfig{
+		BufferSize: ASYNC_JOB_QUEUE_MAXLEN,
+		Workers:    1,
+	}
+)
+
+type GroupConfig struct {
+	// the buffer size of the job queue.
+	BufferSize int
+	// the number of goroutines, jobs are executed in order only if it's 1,
+	// use PutJobKeyed to keep the order of the jobs with the same key.
+	Workers int
+	// the elastic goroutines are started for the backlog if it's more than Workers,
+	// and they exit after idle for ElasticIdle.
+	MaxWorkers  int
+	ElasticIdle time.Duration
+	// the group is closed and removed after it's idle for the duration, it's never reaped if it's zero.
+	IdleTimeout time.Duration
+}
+
+type GroupInfo struct {
+	Name   string
+	Config GroupConfig
+	// the number of running goroutines.
+	Workers    int
+	Depth      int
+	Capacity   int
+	LastActive time.Time
+}
+
+func (this GroupConfig) withDefaults() GroupConfig {
+	if this.BufferSize <= 0 {
+		this.BufferSize = ASYNC_JOB_QUEUE_MAXLEN
+	}
+	if this.Workers <= 0 {
+		this.Workers = 1
+	}
+	if this.MaxWorkers < this.Workers {
+		this.MaxWorkers = this.Workers
+	}
+	if this.ElasticIdle <= 0 {
+		this.ElasticIdle = GROUP_ELASTIC_IDLE
+	}
+	return this
+}
+
+// Create a job worker group with the config, the zero fields are set to default values.
+func CreateGroup(group string, config GroupConfig) error {
+	defer JobWorkersLock.Unlock()
+	JobWorkersLock.Lock()
+	if atomic.LoadInt32(&jobWorkersClosed) == 1 {
+		return ErrClosed
+	}
+	if _, ok := JobWorkers[group]; ok {
+		return ErrGroupExists
+	}
+	JobWorkers[group] = newJobWorker(group, config.withDefaults())
+	log.Printf("[NewJobWorker] group=%s\n", group)
+	return nil
+}
+
+// Close and remove the group, the jobs queued are still executed.
+func CloseGroup(group string) error {
+	JobWorkersLock.Lock()
+	worker, ok := JobWorkers[group]
+	delete(JobWorkers, group)
+	JobWorkersLock.Unlock()
+	if !ok {
+		return ErrGroupNotFound
+	}
+	worker.close()
+	return nil
+}
+
+// List the groups by name.
+func Groups() []GroupInfo {
+	JobWorkersLock.RLock()
+	groups := make([]GroupInfo, 0, len(JobWorkers))
+	for group, worker := range JobWorkers {
+		groups = append(groups, GroupInfo{
+			Name:       group,
+			Config:     worker.config,
+			Workers:    worker.workers(),
+			Depth:      len(worker.jobQueue),
+			Capacity:   cap(worker.jobQueue),
+			LastActive: time.Unix(0, atomic.LoadInt64(&worker.active)),
+		})
+	}
+	JobWorkersLock.RUnlock()
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+	return groups
+}
+
+// Check if no job is queued or running.
+func (this *JobWorker) idle() bool {
+	if len(this.jobQueue) > 0 {
+		return false
+	}
+	for _, c := range this.currents {
+		if atomic.LoadInt64(&c.start) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Close and remove the worker after it's idle for the timeout.
+func (this *JobWorker) reap() {
+	ticker := time.NewTicker(this.config.IdleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-this.quit:
+			return
+		case <-ticker.C:
+			active := time.Unix(0, atomic.LoadInt64(&this.active))
+			if time.Now().Sub(active) < this.config.IdleTimeout || !this.idle() {
+				continue
+			}
+			JobWorkersLock.Lock()
+			if JobWorkers[this.group] == this {
+				delete(JobWorkers, this.group)
+				atomic.StoreInt32(&this.reaped, 1)
+			}
+			JobWorkersLock.Unlock()
+			log.Printf("[ReapJobWorker] group=%s\n", this.group)
+			this.close()
+			return
+		}
+	}
+}
+
+// The number of running goroutines.
+func (this *JobWorker) workers() (n int) {
+	for _, c := range this.currents {
+		n += int(atomic.LoadInt32(&c.alive))
+	}
+	return
+}
+
+// Start an elastic goroutine for the backlog if the maximum is not reached,
+// it must be called with the read lock before closed.
+func (this *JobWorker) grow() {
+	for _, cur := range this.currents[this.config.Workers:] {
+		if atomic.CompareAndSwapInt32(&cur.alive, 0, 1) {
+			this.wg.Add(1)
+			go this.elastic(cur)
+			return
+		}
+	}
+}
+
+// The elastic goroutine exits after it's idle for a while.
+func (this *JobWorker) elastic(cur *current) {
+	defer this.wg.Done()
+	defer atomic.StoreInt32(&cur.alive, 0)
+	atomic.StoreInt64(&cur.gid, goroutineID())
+	timer := time.NewTimer(this.config.ElasticIdle)
+	defer timer.Stop()
+	for {
+		select {
+		case msg, ok := <-this.jobQueue:
+			if !ok {
+				return
+			}
+			this.execute(msg, cur)
+			timer.Reset(this.config.ElasticIdle)
+		case <-timer.C:
+			return
+		}
+	}
+}
+
+// Jobs with the same key are executed serially and in order in the group, even if it has many goroutines,
+// but the jobs delayed by limits run after the jobs of the key put meanwhile.
+func (this *Post) PutJobKeyed(group string, key interface{}, f interface{}, params ...interface{}) error {
+	return putGroupKeyed(group, key, &QueueMsg{Func: f, Params: params})
+}
+
+// The limits of the group apply to each job rather than the drain jobs of the mailboxes.
+func putGroupKeyed(group string, key interface{}, msg *QueueMsg) error {
+	hash := keyHash(key)
+	if msg.requeue == nil {
+		msg.requeue = func(msg *QueueMsg) error {
+			return putGroupKeyed(group, key, msg)
+		}
+	}
+	for {
+		worker, err := getJobWorker(group)
+		if err != nil {
+			msg.release()
+			return err
+		}
+		if ok, err := worker.limit(msg); !ok {
+			return err
+		}
+		shard, box, schedule := worker.keyed.push(hash, key, msg)
+		if !schedule {
+			return nil
+		}
+		if err = worker.scheduleBox(shard, box); err == nil {
+			return nil
+		}
+		if shard.rollback(box, msg) {
+			worker.forceBox(group, key, shard, box)
+		}
+		// try the new worker if it's reaped meanwhile.
+		if err != ErrClosed || atomic.LoadInt32(&worker.reaped) == 0 {
+			msg.release()
+			return err
+		}
+	}
+}
+
+// Append a drain job of the mailbox, it fails only if the worker is closed.
+func (this *JobWorker) scheduleBox(shard *keyedShard, box *mailbox) error {
+	return this.sendJob(&QueueMsg{Func: func() {
+		this.drainBox(shard, box)
+	}})
+}
+
+// Put the jobs left in the mailbox of the closed worker to the new worker of the group,
+// they're put by other producers meanwhile and accepted.
+func (this *JobWorker) forceBox(group string, key interface{}, shard *keyedShard, box *mailbox) {
+	for msgs := shard.take(box); len(msgs) > 0; msgs = shard.take(box) {
+		for _, msg := range msgs {
+			if err := putGroupKeyed(group, key, msg); err != nil {
+				msg.resolve(nil, err)
+			}
+		}
+	}
+}
+
+// Execute a batch of jobs in the mailbox, the drain job is appended again if there are more jobs.
+func (this *JobWorker) drainBox(shard *keyedShard, box *mailbox) {
+	// the drain job is watched instead.
+	cur := &current{}
+	for {
+		msgs := shard.take(box)
+		if len(msgs) == 0 {
+			return
+		}
+		for _, msg := range msgs {
+			this.execute(msg, cur)
+		}
+		// the drain job is appended without waiting, or it may block the only goroutine.
+		if shard.more(box) && this.offerJob(&QueueMsg{Func: func() {
+			this.drainBox(shard, box)
+		}}) {
+			return
+		}
+	}
+}
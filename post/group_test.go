@@ -0,0 +1,167 @@
+package post
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func findGroup(name string) (GroupInfo, bool) {
+	for _, info := range Groups() {
+		if info.Name == name {
+			return info, true
+		}
+	}
+	return GroupInfo{}, false
+}
+
+func TestGroup(t *testing.T) {
+	assert.Nil(t, CreateGroup("group", GroupConfig{BufferSize: 8, Workers: 4}))
+	assert.Equal(t, ErrGroupExists, CreateGroup("group", GroupConfig{}))
+
+	var running int32
+	block := make(chan struct{})
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, GPost.PutJob("group", func() {
+			atomic.AddInt32(&running, 1)
+			<-block
+		}))
+	}
+	time.Sleep(2 * MAX_SLEEP_TIME)
+	// 4 jobs are running concurrently and 1 is queued.
+	assert.Equal(t, int32(4), atomic.LoadInt32(&running))
+	info, ok := findGroup("group")
+	assert.True(t, ok)
+	assert.Equal(t, 1, info.Depth)
+	assert.Equal(t, 8, info.Capacity)
+	assert.Equal(t, 4, info.Config.Workers)
+	assert.True(t, time.Now().Sub(info.LastActive) < time.Second)
+
+	close(block)
+	assert.Nil(t, CloseGroup("group"))
+	assert.Equal(t, ErrGroupNotFound, CloseGroup("group"))
+	_, ok = findGroup("group")
+	assert.False(t, ok)
+	time.Sleep(2 * MAX_SLEEP_TIME)
+	assert.Equal(t, int32(5), atomic.LoadInt32(&running))
+}
+
+func TestGroupReap(t *testing.T) {
+	assert.Nil(t, CreateGroup("reap", GroupConfig{IdleTimeout: 20 * time.Millisecond}))
+	var calls int32
+	GPost.PutJob("reap", func() { atomic.AddInt32(&calls, 1) })
+	time.Sleep(60 * time.Millisecond)
+	_, ok := findGroup("reap")
+	assert.False(t, ok)
+
+	// the group is created again by default.
+	assert.Nil(t, GPost.PutJob("reap", func() { atomic.AddInt32(&calls, 1) }))
+	time.Sleep(2 * MAX_SLEEP_TIME)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	info, ok := findGroup("reap")
+	assert.True(t, ok)
+	assert.Equal(t, DefaultGroupConfig.withDefaults(), info.Config)
+	CloseGroup("reap")
+}
+
+func TestGetJobWorker(t *testing.T) {
+	var wg sync.WaitGroup
+	workers := make([]*JobWorker, 8)
+	for i := range workers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			workers[i], _ = getJobWorker("race")
+		}(i)
+	}
+	wg.Wait()
+	for _, worker := range workers {
+		assert.Equal(t, workers[0], worker)
+	}
+	CloseGroup("race")
+}
+
+func TestGroupElastic(t *testing.T) {
+	assert.Nil(t, CreateGroup("elastic", GroupConfig{Workers: 1, MaxWorkers: 4, ElasticIdle: 20 * time.Millisecond}))
+	var running int32
+	block := make(chan struct{})
+	for i := 0; i < 6; i++ {
+		GPost.PutJob("elastic", func() {
+			atomic.AddInt32(&running, 1)
+			<-block
+		})
+	}
+	time.Sleep(2 * MAX_SLEEP_TIME)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&running))
+	info, _ := findGroup("elastic")
+	assert.Equal(t, 4, info.Workers)
+
+	close(block)
+	time.Sleep(6 * MAX_SLEEP_TIME)
+	assert.Equal(t, int32(6), atomic.LoadInt32(&running))
+	info, _ = findGroup("elastic")
+	assert.Equal(t, 1, info.Workers)
+	CloseGroup("elastic")
+}
+
+func TestJobKeyed(t *testing.T) {
+	assert.Nil(t, CreateGroup("keyed", GroupConfig{Workers: 4}))
+	var lock sync.Mutex
+	seqs := map[string][]int{}
+	for i := 0; i < 200; i++ {
+		key := []string{"a", "b", "c"}[i%3]
+		assert.Nil(t, GPost.PutJobKeyed("keyed", key, func(key string, i int) {
+			time.Sleep(time.Microsecond)
+			lock.Lock()
+			seqs[key] = append(seqs[key], i)
+			lock.Unlock()
+		}, key, i))
+	}
+	time.Sleep(10 * MAX_SLEEP_TIME)
+	lock.Lock()
+	for _, seq := range seqs {
+		for i := 1; i < len(seq); i++ {
+			assert.True(t, seq[i-1] < seq[i])
+		}
+	}
+	assert.Equal(t, 200, len(seqs["a"])+len(seqs["b"])+len(seqs["c"]))
+	lock.Unlock()
+	CloseGroup("keyed")
+}
+
+func TestJobKeyedLimit(t *testing.T) {
+	// the limit applies to each job, and the dropped jobs leave no mailbox behind.
+	GPost.SetGroupLimit("kdrop", &Limit{Rate: 20, Policy: LIMIT_DROP})
+	defer GPost.SetGroupLimit("kdrop", nil)
+	var ran int32
+	for i := 0; i < 8; i++ {
+		assert.Nil(t, GPost.PutJobKeyed("kdrop", "k", func() { atomic.AddInt32(&ran, 1) }))
+	}
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&ran))
+	assert.Nil(t, GPost.PutJobKeyed("kdrop", "k", func() { atomic.AddInt32(&ran, 1) }))
+	time.Sleep(2 * MAX_SLEEP_TIME)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&ran))
+
+	// the delayed jobs are put to the mailbox again.
+	GPost.SetGroupLimit("kdelay", &Limit{Rate: 100})
+	defer GPost.SetGroupLimit("kdelay", nil)
+	var lock sync.Mutex
+	var seq []int
+	for i := 0; i < 8; i++ {
+		assert.Nil(t, GPost.PutJobKeyed("kdelay", "k", func(i int) {
+			lock.Lock()
+			seq = append(seq, i)
+			lock.Unlock()
+		}, i))
+	}
+	time.Sleep(200 * time.Millisecond)
+	lock.Lock()
+	assert.ElementsMatch(t, []int{0, 1, 2, 3, 4, 5, 6, 7}, seq)
+	lock.Unlock()
+	CloseGroup("kdrop")
+	CloseGroup("kdelay")
+}
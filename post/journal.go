@@ -0,0 +1,328 @@
+// Write-ahead journal for the jobs of named functions, pending jobs are replayed on startup.
+package post
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+const (
+	// the journal is compacted after so many jobs are completed and they're more than the pending ones.
+	JOURNAL_COMPACT_NUM = 10000
+)
+
+type journalRecord struct {
+	Op       string            `json:"op"`
+	ID       uint64            `json:"id"`
+	Func     string            `json:"func,omitempty"`
+	Params   []json.RawMessage `json:"params,omitempty"`
+	Priority Priority          `json:"priority,omitempty"`
+}
+
+type Journal struct {
+	lock     sync.Mutex
+	filePath string
+	file     *os.File
+	// sync the file after each write.
+	sync    bool
+	seq     uint64
+	pending map[uint64]*journalRecord
+	// the jobs completed since the last compaction.
+	done int
+}
+
+// Open the journal and load the pending jobs, they're replayed by Post.SetJournal.
+func OpenJournal(filePath string, sync bool) (*Journal, error) {
+	this := &Journal{
+		filePath: filePath,
+		sync:     sync,
+		pending:  make(map[uint64]*journalRecord),
+	}
+	if err := this.load(); err != nil {
+		return nil, err
+	}
+	if err := this.open(); err != nil {
+		return nil, err
+	}
+	return this, nil
+}
+
+func (this *Journal) open() error {
+	os.MkdirAll(path.Dir(this.filePath), 0755)
+	f, err := os.OpenFile(this.filePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	this.file = f
+	return nil
+}
+
+func (this *Journal) load() error {
+	f, err := os.Open(this.filePath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			record := &journalRecord{}
+			// the last line may be broken by a crash.
+			if json.Unmarshal(line, record) == nil {
+				switch record.Op {
+				case "put":
+					this.pending[record.ID] = record
+				case "done":
+					delete(this.pending, record.ID)
+				}
+				if record.ID > this.seq {
+					this.seq = record.ID
+				}
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// Write a record, it must be called with the lock.
+func (this *Journal) write(record *journalRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err = this.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if this.sync {
+		return this.file.Sync()
+	}
+	return nil
+}
+
+// Write the job before it's queued, the job isn't journaled if it's parameters can't be encoded.
+func (this *Journal) append(msg *QueueMsg) error {
+	record := &journalRecord{
+		Op:       "put",
+		Func:     msg.Func.(string),
+		Params:   make([]json.RawMessage, len(msg.Params)),
+		Priority: msg.Priority,
+	}
+	for i, param := range msg.Params {
+		data, err := json.Marshal(param)
+		if err != nil {
+			return err
+		}
+		record.Params[i] = data
+	}
+
+	defer this.lock.Unlock()
+	this.lock.Lock()
+	if this.file == nil {
+		return ErrClosed
+	}
+	this.seq++
+	record.ID = this.seq
+	if err := this.write(record); err != nil {
+		return err
+	}
+	this.pending[record.ID] = record
+	msg.journal = this
+	msg.journalID = record.ID
+	return nil
+}
+
+// Mark the job as completed.
+func (this *Journal) complete(id uint64) {
+	defer this.lock.Unlock()
+	this.lock.Lock()
+	if _, ok := this.pending[id]; !ok || this.file == nil {
+		return
+	}
+	delete(this.pending, id)
+	if err := this.write(&journalRecord{Op: "done", ID: id}); err != nil {
+		log.Printf("[Journal] complete %d: %v\n", id, err)
+	}
+	this.done++
+	if this.done >= JOURNAL_COMPACT_NUM && this.done > len(this.pending) {
+		if err := this.compact(); err != nil {
+			log.Printf("[Journal] compact: %v\n", err)
+		}
+	}
+}
+
+// The pending jobs ordered by id.
+func (this *Journal) records() []*journalRecord {
+	records := make([]*journalRecord, 0, len(this.pending))
+	for _, record := range this.pending {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ID < records[j].ID
+	})
+	return records
+}
+
+// Rewrite the journal with the pending jobs only, it must be called with the lock.
+func (this *Journal) compact() error {
+	tmp := this.filePath + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(f)
+	for _, record := range this.records() {
+		data, _ := json.Marshal(record)
+		writer.Write(append(data, '\n'))
+	}
+	if err = writer.Flush(); err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err = os.Rename(tmp, this.filePath); err != nil {
+		return err
+	}
+	this.file.Close()
+	this.done = 0
+	return this.open()
+}
+
+// The number of pending jobs.
+func (this *Journal) Len() int {
+	defer this.lock.Unlock()
+	this.lock.Lock()
+	return len(this.pending)
+}
+
+// Compact the journal manually.
+func (this *Journal) Compact() error {
+	defer this.lock.Unlock()
+	this.lock.Lock()
+	if this.file == nil {
+		return ErrClosed
+	}
+	return this.compact()
+}
+
+func (this *Journal) Close() error {
+	defer this.lock.Unlock()
+	this.lock.Lock()
+	if this.file == nil {
+		return nil
+	}
+	err := this.file.Close()
+	this.file = nil
+	return err
+}
+
+// Decode the parameters into the types of the function.
+func decodeParams(function interface{}, raws []json.RawMessage) ([]interface{}, error) {
+	typ := reflect.TypeOf(function)
+	if typ == nil || typ.Kind() != reflect.Func {
+		return nil, fmt.Errorf("invalid function %v", function)
+	}
+	offset := 0
+	if acceptContext(typ) {
+		offset = 1
+	}
+	params := make([]interface{}, len(raws))
+	for i, raw := range raws {
+		var in reflect.Type
+		switch n := i + offset; {
+		case typ.IsVariadic() && n >= typ.NumIn()-1:
+			in = typ.In(typ.NumIn() - 1).Elem()
+		case n < typ.NumIn():
+			in = typ.In(n)
+		default:
+			return nil, fmt.Errorf("too many parameters for %v", typ)
+		}
+		val := reflect.New(in)
+		if err := json.Unmarshal(raw, val.Interface()); err != nil {
+			return nil, err
+		}
+		params[i] = val.Elem().Interface()
+	}
+	return params, nil
+}
+
+// Set the journal for the jobs of registered functions and replay the pending jobs,
+// so it should be called after the functions are registered.
+func (this *Post) SetJournal(journal *Journal) (replayed int, err error) {
+	this.journal.Store(journal)
+	if journal == nil {
+		return
+	}
+	journal.lock.Lock()
+	records := journal.records()
+	journal.lock.Unlock()
+	for _, record := range records {
+		function, ok := this.Functions.Get(record.Func)
+		if !ok {
+			log.Printf("[Journal] remote function(%v) not found\n", record.Func)
+			journal.complete(record.ID)
+			continue
+		}
+		params, e := decodeParams(function, record.Params)
+		if e != nil {
+			log.Printf("[Journal] decode %d: %v\n", record.ID, e)
+			journal.complete(record.ID)
+			continue
+		}
+		o := this.pick(record.Func)
+		if o == nil {
+			return replayed, this.noWorkersError()
+		}
+		msg := o.newMsg(record.Func, nil, params, nil, false)
+		msg.Priority = record.Priority
+		msg.journal = journal
+		msg.journalID = record.ID
+		if err = this.putMsg(o, msg); err != nil {
+			return
+		}
+		replayed++
+	}
+	return
+}
+
+// Journal the job of a registered function before it's queued, it returns true if it's journaled.
+func (this *Post) journalMsg(msg *QueueMsg) bool {
+	journal := this.journal.Load()
+	if journal == nil || msg.journal != nil {
+		return false
+	}
+	if _, ok := msg.Func.(string); !ok {
+		return false
+	}
+	if err := journal.append(msg); err != nil {
+		log.Printf("[Journal] %v: %v\n", msg.Func, err)
+		return false
+	}
+	return true
+}
+
+// Mark the job as completed in the journal.
+func (this *QueueMsg) complete() {
+	if this.journal != nil {
+		this.journal.complete(this.journalID)
+		this.journal = nil
+	}
+}
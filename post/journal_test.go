@@ -0,0 +1,83 @@
+package post
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type credit struct {
+	User   string
+	Amount int
+}
+
+func TestJournal(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "journal")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "post.journal")
+	journal, err := OpenJournal(path, true)
+	assert.Nil(t, err)
+
+	p := NewPost(uint64(64), 1)
+	p.Register("credit", func(c credit, tags ...string) {})
+	replayed, err := p.SetJournal(journal)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, replayed)
+
+	block := make(chan struct{})
+	p.PutQueue(func() { <-block })
+	assert.Nil(t, p.PutQueue("credit", credit{"a", 5}, "vip"))
+	assert.Nil(t, p.PutQueue("credit", credit{"b", 7}))
+	// jobs of anonymous functions aren't journaled.
+	p.PutQueue(func() {})
+	assert.Equal(t, 2, journal.Len())
+	// crash before the jobs are completed.
+	journal.Close()
+	close(block)
+	p.Close()
+
+	journal, err = OpenJournal(path, false)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, journal.Len())
+	var lock sync.Mutex
+	credits := []credit{}
+	p = NewPost(uint64(64), 1)
+	p.Register("credit", func(ctx context.Context, c credit, tags ...string) {
+		lock.Lock()
+		credits = append(credits, c)
+		lock.Unlock()
+	})
+	replayed, err = p.SetJournal(journal)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, replayed)
+	time.Sleep(5 * MAX_SLEEP_TIME)
+	lock.Lock()
+	assert.Equal(t, []credit{{"a", 5}, {"b", 7}}, credits)
+	lock.Unlock()
+	assert.Equal(t, 0, journal.Len())
+
+	assert.Nil(t, journal.Compact())
+	data, _ := ioutil.ReadFile(path)
+	assert.Equal(t, 0, len(data))
+	journal.Close()
+	p.Close()
+}
+
+func TestDecodeParams(t *testing.T) {
+	raws := []json.RawMessage{json.RawMessage(`1`), json.RawMessage(`"a"`), json.RawMessage(`"b"`)}
+	params, err := decodeParams(func(n int, s ...string) {}, raws)
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{1, "a", "b"}, params)
+
+	_, err = decodeParams(func(n int) {}, raws)
+	assert.NotNil(t, err)
+	_, err = decodeParams(func(s string) {}, raws[:1])
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,242 @@
+// Key-ordered execution, jobs of the same key run serially and in order
+// while different keys run in parallel.
+package post
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// the number of shards of mailboxes.
+	KEYED_SHARD_NUM = 64
+	// the maximum jobs executed for a key before yielding to other jobs.
+	KEYED_BATCH_NUM = 64
+)
+
+// The pending jobs of a key, it's removed when there are no more jobs.
+type mailbox struct {
+	key  interface{}
+	msgs []*QueueMsg
+	// a drain job of the mailbox is in queue or running.
+	scheduled bool
+}
+
+type keyedShard struct {
+	lock  sync.Mutex
+	boxes map[interface{}]*mailbox
+}
+
+type keyedRouter struct {
+	shards [KEYED_SHARD_NUM]keyedShard
+}
+
+func newKeyedRouter() *keyedRouter {
+	router := &keyedRouter{}
+	for i := range router.shards {
+		router.shards[i].boxes = make(map[interface{}]*mailbox)
+	}
+	return router
+}
+
+// Hash the key, it must be comparable.
+func keyHash(key interface{}) uint64 {
+	switch k := key.(type) {
+	case int:
+		return uint64(k)
+	case int32:
+		return uint64(k)
+	case int64:
+		return uint64(k)
+	case uint:
+		return uint64(k)
+	case uint32:
+		return uint64(k)
+	case uint64:
+		return k
+	}
+	h := fnv.New64a()
+	if s, ok := key.(string); ok {
+		h.Write([]byte(s))
+	} else {
+		fmt.Fprint(h, key)
+	}
+	return h.Sum64()
+}
+
+// Select the object for the key by hash, nil will be returned if no object is running.
+func (this *Post) pickKey(hash uint64) *RpcObject {
+	objects := this.runningObjects()
+	if len(objects) == 0 {
+		return nil
+	}
+	return objects[hash%uint64(len(objects))]
+}
+
+// Jobs with the same key are executed serially and in order, even if the pool is resized,
+// but the jobs delayed by limits or retried run after the jobs of the key put meanwhile.
+// The key must be comparable such as a player ID or a room ID.
+func (this *Post) PutQueueKeyed(key interface{}, f interface{}, params ...interface{}) error {
+	return this.putKeyed(key, &QueueMsg{Func: f, Params: params})
+}
+
+// The job will be skipped if ctx is done before execution,
+// and ctx is passed in if the first parameter of f is a context.Context.
+func (this *Post) PutQueueKeyedCtx(ctx context.Context, key interface{}, f interface{}, params ...interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return this.putKeyed(key, &QueueMsg{Func: f, Params: params, Ctx: ctx})
+}
+
+// Append the job to the mailbox of the key, the mailbox should be scheduled if schedule is true.
+func (this *keyedRouter) push(hash uint64, key interface{}, msg *QueueMsg) (shard *keyedShard, box *mailbox, schedule bool) {
+	shard = &this.shards[hash%KEYED_SHARD_NUM]
+	defer shard.lock.Unlock()
+	shard.lock.Lock()
+	box, ok := shard.boxes[key]
+	if !ok {
+		box = &mailbox{key: key}
+		shard.boxes[key] = box
+	}
+	box.msgs = append(box.msgs, msg)
+	if box.scheduled {
+		return shard, box, false
+	}
+	box.scheduled = true
+	return shard, box, true
+}
+
+// Remove the job whose mailbox failed to be scheduled, the mailbox is removed if it's empty.
+// It returns true if the jobs appended by other producers meanwhile are left, they're accepted,
+// so the mailbox is kept scheduled and must be scheduled by the caller.
+func (this *keyedShard) rollback(box *mailbox, msg *QueueMsg) bool {
+	defer this.lock.Unlock()
+	this.lock.Lock()
+	for i, m := range box.msgs {
+		if m == msg {
+			box.msgs = append(box.msgs[:i], box.msgs[i+1:]...)
+			break
+		}
+	}
+	if len(box.msgs) > 0 {
+		return true
+	}
+	box.msgs = nil
+	box.scheduled = false
+	delete(this.boxes, box.key)
+	return false
+}
+
+// Take a batch of jobs from the mailbox, the mailbox is removed if it's empty.
+func (this *keyedShard) take(box *mailbox) []*QueueMsg {
+	defer this.lock.Unlock()
+	this.lock.Lock()
+	msgs := box.msgs
+	if len(msgs) > KEYED_BATCH_NUM {
+		msgs = msgs[:KEYED_BATCH_NUM:KEYED_BATCH_NUM]
+		box.msgs = box.msgs[KEYED_BATCH_NUM:]
+	} else {
+		box.msgs = nil
+	}
+	if len(msgs) == 0 {
+		box.scheduled = false
+		delete(this.boxes, box.key)
+	}
+	return msgs
+}
+
+func (this *keyedShard) more(box *mailbox) bool {
+	defer this.lock.Unlock()
+	this.lock.Lock()
+	return len(box.msgs) > 0
+}
+
+// Admit the job like the other submissions and append it to the mailbox of the key,
+// the jobs delayed by limits or retried are appended to the mailbox again after the delay,
+// so they run after the jobs of the key put meanwhile.
+func (this *Post) putKeyed(key interface{}, msg *QueueMsg) (err error) {
+	hash := keyHash(key)
+	o := this.pickKey(hash)
+	if o == nil {
+		return this.noWorkersError()
+	}
+	if msg.requeue == nil {
+		msg.requeue = func(msg *QueueMsg) error {
+			return this.putKeyed(key, msg)
+		}
+	}
+	ok, journaled, err := this.admit(o, msg)
+	if !ok {
+		return err
+	}
+	shard, box, schedule := this.keyed.push(hash, key, msg)
+	if !schedule {
+		return nil
+	}
+	if err = this.scheduleBox(hash, shard, box); err != nil {
+		if shard.rollback(box, msg) {
+			this.forceBox(hash, shard, box)
+		}
+		msg.release()
+		if journaled {
+			msg.complete()
+		}
+	}
+	return
+}
+
+// Put a drain job of the mailbox to the object selected by the key.
+func (this *Post) scheduleBox(hash uint64, shard *keyedShard, box *mailbox) error {
+	o := this.pickKey(hash)
+	if o == nil {
+		return this.noWorkersError()
+	}
+	return this.putMsg(o, this.drainMsg(hash, shard, box))
+}
+
+// The drain job of the mailbox.
+func (this *Post) drainMsg(hash uint64, shard *keyedShard, box *mailbox) *QueueMsg {
+	// the drain job may be migrated or stolen, so it runs with the object executing it.
+	return &QueueMsg{Func: objectJob(func(o *RpcObject) {
+		this.drainBox(o, hash, shard, box)
+	})}
+}
+
+// Schedule the mailbox of the jobs already accepted, the drain job is spilled if the queue is full,
+// and the jobs are dropped if no object is running.
+func (this *Post) forceBox(hash uint64, shard *keyedShard, box *mailbox) {
+	if this.scheduleBox(hash, shard, box) == nil {
+		return
+	}
+	if o := this.pickKey(hash); o != nil && atomic.LoadInt32(&o.closed) == 0 {
+		o.spillMsg(this.drainMsg(hash, shard, box), true)
+		return
+	}
+	err := this.noWorkersError()
+	for msgs := shard.take(box); len(msgs) > 0; msgs = shard.take(box) {
+		for _, msg := range msgs {
+			msg.resolve(nil, err)
+		}
+	}
+}
+
+// Execute a batch of jobs in the mailbox by the object, the drain job is rescheduled if there are more jobs.
+func (this *Post) drainBox(o *RpcObject, hash uint64, shard *keyedShard, box *mailbox) {
+	for {
+		msgs := shard.take(box)
+		if len(msgs) == 0 {
+			return
+		}
+		for _, msg := range msgs {
+			o.executeMsg(msg)
+		}
+		// yield to other jobs, or keep draining in this routine if the reschedule failed.
+		if shard.more(box) && this.scheduleBox(hash, shard, box) == nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package post
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyed(t *testing.T) {
+	p := NewPost(uint64(1024), 3)
+	var lock sync.Mutex
+	seqs := map[int][]int{}
+	for i := 0; i < 300; i++ {
+		key := i % 3
+		p.PutQueueKeyed(key, func(key, i int) {
+			lock.Lock()
+			seqs[key] = append(seqs[key], i)
+			lock.Unlock()
+		}, key, i)
+		// resize the pool meanwhile.
+		if i == 100 {
+			p.DelOne()
+		} else if i == 200 {
+			p.AddOne()
+		}
+	}
+	time.Sleep(30 * time.Millisecond)
+	lock.Lock()
+	for key := 0; key < 3; key++ {
+		assert.Equal(t, 100, len(seqs[key]))
+		for j, i := range seqs[key] {
+			assert.Equal(t, key+j*3, i)
+		}
+	}
+	lock.Unlock()
+
+	// jobs of the same key never run concurrently.
+	running, overlap := 0, false
+	for i := 0; i < 100; i++ {
+		p.PutQueueKeyed("room", func() {
+			lock.Lock()
+			running++
+			overlap = overlap || running > 1
+			lock.Unlock()
+			time.Sleep(10 * time.Microsecond)
+			lock.Lock()
+			running--
+			lock.Unlock()
+		})
+	}
+	time.Sleep(30 * time.Millisecond)
+	lock.Lock()
+	assert.Equal(t, false, overlap)
+	lock.Unlock()
+	p.Close()
+	assert.Equal(t, ErrClosed, p.PutQueueKeyed("other", func() {}))
+}
+
+func TestKeyedAdmit(t *testing.T) {
+	p := NewPost(uint64(1024), 2)
+	defer p.Close()
+	var lock sync.Mutex
+	var seq []int
+	p.Register("keyed", func(i int) {
+		lock.Lock()
+		seq = append(seq, i)
+		lock.Unlock()
+	})
+	// the parameters are checked before queued.
+	assert.True(t, errors.Is(p.PutQueueKeyed(1, "keyed", "bad"), ErrBadParams))
+
+	// the delayed jobs are put to the mailbox again.
+	p.SetLimit("keyed", &Limit{Rate: 200})
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, p.PutQueueKeyed(1, "keyed", i))
+	}
+	time.Sleep(100 * time.Millisecond)
+	lock.Lock()
+	assert.ElementsMatch(t, []int{0, 1, 2, 3, 4}, seq)
+	lock.Unlock()
+}
+
+func TestKeyedExecutor(t *testing.T) {
+	p := NewPost(uint64(1024), 2)
+	defer p.Close()
+	var executor *RpcObject
+	done := make(chan struct{})
+	// the drain job runs with the object executing it, even if it's migrated.
+	obj := &RpcObject{}
+	obj.Init(64)
+	obj.PutQueue(objectJob(func(o *RpcObject) {
+		executor = o
+		close(done)
+	}), false)
+	for _, msg := range obj.takeAll() {
+		p.objects[1].putMsg(msg)
+	}
+	<-done
+	assert.Equal(t, p.objects[1], executor)
+}
+
+func TestKeyedRollback(t *testing.T) {
+	p := NewPost(uint64(16), 1)
+	defer p.Close()
+	block := blockPost(p)
+	for p.PutQueue(func() {}) == nil {
+	}
+	var done int32
+	job := func() { atomic.AddInt32(&done, 1) }
+
+	// the job put meanwhile is kept when the drain job of the first one fails to be queued.
+	hash := keyHash("k")
+	first, second := &QueueMsg{Func: job}, &QueueMsg{Func: job}
+	shard, box, schedule := p.keyed.push(hash, "k", first)
+	assert.True(t, schedule)
+	_, _, schedule = p.keyed.push(hash, "k", second)
+	assert.False(t, schedule)
+	assert.NotNil(t, p.scheduleBox(hash, shard, box))
+	assert.True(t, shard.rollback(box, first))
+	assert.Equal(t, []*QueueMsg{second}, box.msgs)
+	p.forceBox(hash, shard, box)
+
+	// the accepted jobs of concurrent producers are all executed.
+	var accepted int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if p.PutQueueKeyed("k", job) == nil {
+					atomic.AddInt32(&accepted, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(block)
+	time.Sleep(5 * MAX_SLEEP_TIME)
+	assert.Equal(t, atomic.LoadInt32(&accepted)+1, atomic.LoadInt32(&done))
+	shard.lock.Lock()
+	assert.Equal(t, 0, len(shard.boxes))
+	shard.lock.Unlock()
+}
@@ -0,0 +1,206 @@
+// Rate limits and concurrency caps for registered functions and job worker groups.
+package post
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// delay the excess jobs until they're allowed, by the timer if it's imported.
+	LIMIT_DELAY LimitPolicy = iota
+	// reject the excess jobs with ErrRateLimited.
+	LIMIT_REJECT
+	// drop the excess jobs silently.
+	LIMIT_DROP
+)
+
+const (
+	// the interval to check again for the jobs delayed by the in-flight cap.
+	LIMIT_POLL_INTERVAL = 10 * time.Millisecond
+)
+
+var (
+	ErrRateLimited = errors.New("post: rate limited")
+	// the limiters of job worker groups.
+	groupLimiters sync.Map
+)
+
+type LimitPolicy int32
+
+type Limit struct {
+	// the jobs allowed per second, it's unlimited if it's zero.
+	Rate float64
+	// the maximum jobs allowed at once, 1 by default.
+	Burst int
+	// the maximum jobs queued or running, it's unlimited if it's zero.
+	MaxInFlight int64
+	Policy      LimitPolicy
+}
+
+// A token bucket with the in-flight counter.
+type limiter struct {
+	config   Limit
+	lock     sync.Mutex
+	tokens   float64
+	last     time.Time
+	inflight int64
+}
+
+func newLimiter(config Limit) *limiter {
+	if config.Burst <= 0 {
+		config.Burst = 1
+	}
+	return &limiter{
+		config: config,
+		tokens: float64(config.Burst),
+		last:   time.Now(),
+	}
+}
+
+// Take a token, it returns the time to wait if it's not allowed.
+func (this *limiter) acquire() (wait time.Duration, ok bool) {
+	defer this.lock.Unlock()
+	this.lock.Lock()
+	if this.config.MaxInFlight > 0 && atomic.LoadInt64(&this.inflight) >= this.config.MaxInFlight {
+		return LIMIT_POLL_INTERVAL, false
+	}
+	if rate := this.config.Rate; rate > 0 {
+		now := time.Now()
+		this.tokens += now.Sub(this.last).Seconds() * rate
+		if burst := float64(this.config.Burst); this.tokens > burst {
+			this.tokens = burst
+		}
+		this.last = now
+		if this.tokens < 1 {
+			return time.Duration((1 - this.tokens) / rate * float64(time.Second)), false
+		}
+		this.tokens--
+	}
+	atomic.AddInt64(&this.inflight, 1)
+	return 0, true
+}
+
+// Release the job which is finished or failed to put.
+func (this *limiter) release() {
+	atomic.AddInt64(&this.inflight, -1)
+}
+
+// Release the limiter of the message if any.
+func (this *QueueMsg) release() {
+	if this.limiter != nil {
+		this.limiter.release()
+		this.limiter = nil
+	}
+}
+
+// Set the limit of the registered function, it's removed if limit is nil.
+func (this *Post) SetLimit(id string, limit *Limit) {
+	if limit == nil {
+		this.limiters.Delete(id)
+		return
+	}
+	this.limiters.Store(id, newLimiter(*limit))
+}
+
+// Set the limit of the job worker group, it's removed if limit is nil.
+func (this *Post) SetGroupLimit(group string, limit *Limit) {
+	if limit == nil {
+		groupLimiters.Delete(group)
+		return
+	}
+	groupLimiters.Store(group, newLimiter(*limit))
+}
+
+// Apply the limit of the registered function, it returns false if the job isn't put now.
+func (this *Post) limit(o *RpcObject, msg *QueueMsg) (bool, error) {
+	ok, settle, err := this.deferLimit(o, msg)
+	if settle != nil {
+		settle()
+	}
+	return ok, err
+}
+
+// Apply the limit of the registered function, the job which isn't put now is dropped or delayed by settle,
+// so an all-or-nothing batch can leave it untouched when the batch is aborted.
+func (this *Post) deferLimit(o *RpcObject, msg *QueueMsg) (bool, func(), error) {
+	if msg.limiter != nil {
+		return true, nil, nil
+	}
+	id, ok := msg.Func.(string)
+	if !ok {
+		return true, nil, nil
+	}
+	val, ok := this.limiters.Load(id)
+	if !ok {
+		return true, nil, nil
+	}
+	l := val.(*limiter)
+	wait, ok := l.acquire()
+	if ok {
+		msg.limiter = l
+		return true, nil, nil
+	}
+	switch l.config.Policy {
+	case LIMIT_REJECT:
+		atomic.AddUint64(&o.stats.putFailed, 1)
+		return false, nil, ErrRateLimited
+	case LIMIT_DROP:
+		return false, func() { o.dropMsg(msg, ErrRateLimited) }, nil
+	default:
+		// the keyed jobs are put to their mailboxes again.
+		requeue := this.requeue
+		if msg.requeue != nil {
+			requeue = msg.requeue
+		}
+		return false, func() {
+			retryScheduler(wait, func() {
+				if err := requeue(msg); err != nil {
+					msg.resolve(nil, err)
+				}
+			})
+		}, nil
+	}
+}
+
+// Apply the limit of the job worker group, it returns false if the job isn't appended now.
+func (this *JobWorker) limit(msg *QueueMsg) (bool, error) {
+	if msg.limiter != nil {
+		return true, nil
+	}
+	val, ok := groupLimiters.Load(this.group)
+	if !ok {
+		return true, nil
+	}
+	l := val.(*limiter)
+	wait, ok := l.acquire()
+	if ok {
+		msg.limiter = l
+		return true, nil
+	}
+	switch l.config.Policy {
+	case LIMIT_REJECT:
+		atomic.AddUint64(&this.stats.putFailed, 1)
+		return false, ErrRateLimited
+	case LIMIT_DROP:
+		atomic.AddUint64(&this.stats.dropped, 1)
+		msg.resolve(nil, ErrRateLimited)
+	default:
+		// the keyed jobs are put to their mailboxes again.
+		group := this.group
+		requeue := msg.requeue
+		if requeue == nil {
+			requeue = func(msg *QueueMsg) error {
+				return appendGroupJob(nil, group, msg)
+			}
+		}
+		retryScheduler(wait, func() {
+			if err := requeue(msg); err != nil {
+				msg.resolve(nil, err)
+			}
+		})
+	}
+	return false, nil
+}
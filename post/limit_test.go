@@ -0,0 +1,91 @@
+package post
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter(t *testing.T) {
+	l := newLimiter(Limit{Rate: 100, Burst: 2, MaxInFlight: 3})
+	for i := 0; i < 2; i++ {
+		_, ok := l.acquire()
+		assert.True(t, ok)
+	}
+	wait, ok := l.acquire()
+	assert.False(t, ok)
+	assert.True(t, wait > 0 && wait <= 10*time.Millisecond)
+
+	time.Sleep(25 * time.Millisecond)
+	_, ok = l.acquire()
+	assert.True(t, ok)
+	// the in-flight cap is reached.
+	wait, ok = l.acquire()
+	assert.False(t, ok)
+	assert.Equal(t, LIMIT_POLL_INTERVAL, wait)
+	l.release()
+	_, ok = l.acquire()
+	assert.True(t, ok)
+}
+
+func TestLimitDelay(t *testing.T) {
+	var lock sync.Mutex
+	times := []time.Time{}
+	p := NewPost(uint64(64), 2)
+	p.Register("pay", func() {
+		lock.Lock()
+		times = append(times, time.Now())
+		lock.Unlock()
+	})
+	p.SetLimit("pay", &Limit{Rate: 50})
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, p.PutQueue("pay"))
+	}
+	time.Sleep(200 * time.Millisecond)
+	lock.Lock()
+	assert.Equal(t, 5, len(times))
+	// 50 jobs per second at most.
+	assert.True(t, times[4].Sub(start) >= 70*time.Millisecond)
+	lock.Unlock()
+	p.Close()
+}
+
+func TestLimitReject(t *testing.T) {
+	p := NewPost(uint64(64), 1)
+	block := make(chan struct{})
+	p.Register("pay", func() { <-block })
+	p.SetLimit("pay", &Limit{MaxInFlight: 1, Policy: LIMIT_REJECT})
+	assert.Nil(t, p.PutQueue("pay"))
+	assert.Equal(t, ErrRateLimited, p.PutQueue("pay"))
+	close(block)
+	time.Sleep(2 * MAX_SLEEP_TIME)
+	assert.Nil(t, p.PutQueue("pay"))
+
+	p.SetLimit("pay", nil)
+	assert.Nil(t, p.PutQueue("pay"))
+	assert.Nil(t, p.PutQueue("pay"))
+	p.Close()
+}
+
+func TestGroupLimit(t *testing.T) {
+	GPost.SetGroupLimit("limit", &Limit{MaxInFlight: 1, Policy: LIMIT_DROP})
+	defer GPost.SetGroupLimit("limit", nil)
+	dropped := GPost.Stats().Groups["limit"].Dropped
+	block := make(chan struct{})
+	var calls int32
+	assert.Nil(t, GPost.PutJob("limit", func() {
+		<-block
+		atomic.AddInt32(&calls, 1)
+	}))
+	assert.Nil(t, GPost.PutJob("limit", func() {
+		atomic.AddInt32(&calls, 1)
+	}))
+	close(block)
+	time.Sleep(2 * MAX_SLEEP_TIME)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	assert.Equal(t, dropped+1, GPost.Stats().Groups["limit"].Dropped)
+}
@@ -0,0 +1,231 @@
+// Middlewares around the execution of jobs in objects and job workers.
+package post
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/TianQinS/fastapi/basic"
+)
+
+const (
+	// the replacement of redacted parameters.
+	REDACTED = "***"
+)
+
+var (
+	// the middlewares of job worker groups.
+	groupMiddlewares sync.Map
+)
+
+// A job passed through the middlewares, it's recycled with the message after the handler returns.
+type Job struct {
+	Msg *QueueMsg
+	// the resolved function of the message.
+	Func interface{}
+	// the group of the job worker, it's empty for objects.
+	Group string
+	// the parameters for logs and reports, the redacted ones are replaced.
+	Args []interface{}
+}
+
+// Handler executes the job, the error or panic fails the job like a panic of the function.
+type Handler func(job *Job) ([]interface{}, error)
+
+// Middleware wraps the next handler, the first one of a chain is the outermost.
+type Middleware func(next Handler) Handler
+
+// The policy to handle a recovered panic, the job succeeds if it returns nil.
+type RecoverPolicy func(job *Job, info interface{}) error
+
+// The middlewares and the handler composed of them.
+type chain struct {
+	middlewares []Middleware
+	handler     Handler
+}
+
+// Tracer starts a span for each job.
+type Tracer interface {
+	Start(job *Job) Span
+}
+
+type Span interface {
+	End(err error)
+}
+
+// The name of the job's function, it's the id for named calls.
+func (this *Job) Name() string {
+	return funcName(this.Msg.Func)
+}
+
+// The arguments of reports for the job.
+func (this *Job) report() map[string]interface{} {
+	return map[string]interface{}{
+		"func":    this.Name(),
+		"params":  append([]interface{}(nil), this.Args...),
+		"attempt": this.Msg.Attempt,
+		"group":   this.Group,
+	}
+}
+
+// The innermost handler which calls the function.
+func callJob(job *Job) ([]interface{}, error) {
+	return job.Msg.call(job.Func), nil
+}
+
+func newChain(middlewares []Middleware) *chain {
+	handler := Handler(callJob)
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return &chain{middlewares: middlewares, handler: handler}
+}
+
+// Append the middlewares to the chain, a new chain is returned.
+func (this *chain) use(middlewares []Middleware) *chain {
+	var all []Middleware
+	if this != nil {
+		all = append(all, this.middlewares...)
+	}
+	return newChain(append(all, middlewares...))
+}
+
+// The handler of the chain, callJob if it's nil.
+func (this *chain) get() Handler {
+	if this == nil {
+		return callJob
+	}
+	return this.handler
+}
+
+// Append the middlewares to the chain of the object.
+func (this *RpcObject) Use(middlewares ...Middleware) {
+	for {
+		old := this.chain.Load()
+		if this.chain.CompareAndSwap(old, old.use(middlewares)) {
+			return
+		}
+	}
+}
+
+// Append the middlewares to the chain of all objects, including the ones added later.
+func (this *Post) Use(middlewares ...Middleware) {
+	defer this.lock.Unlock()
+	this.lock.Lock()
+	this.middlewares = append(this.middlewares, middlewares...)
+	for _, o := range this.objects {
+		o.Use(middlewares...)
+	}
+	this.Object.Use(middlewares...)
+}
+
+// Append the middlewares to the chain of the group.
+func (this *Post) UseGroup(group string, middlewares ...Middleware) {
+	for {
+		val, ok := groupMiddlewares.Load(group)
+		if !ok {
+			if _, loaded := groupMiddlewares.LoadOrStore(group, newChain(middlewares)); !loaded {
+				return
+			}
+			continue
+		}
+		if groupMiddlewares.CompareAndSwap(group, val, val.(*chain).use(middlewares)) {
+			return
+		}
+	}
+}
+
+func (this *JobWorker) handler() Handler {
+	if val, ok := groupMiddlewares.Load(this.group); ok {
+		return val.(*chain).get()
+	}
+	return callJob
+}
+
+// Observe the execution time of each job.
+func Timing(observe func(job *Job, elapsed time.Duration)) Middleware {
+	return func(next Handler) Handler {
+		return func(job *Job) ([]interface{}, error) {
+			start := time.Now()
+			defer func() {
+				observe(job, time.Now().Sub(start))
+			}()
+			return next(job)
+		}
+	}
+}
+
+// Log the jobs which take longer than the threshold.
+func SlowLog(threshold time.Duration) Middleware {
+	return Timing(func(job *Job, elapsed time.Duration) {
+		if elapsed >= threshold {
+			log.Printf("[SlowCall] %s group=%s elapsed=%v args=%+v\n", job.Name(), job.Group, elapsed, job.Args)
+		}
+	})
+}
+
+// Recover the panics of jobs by the policy, see RecoverSkip and RecoverFail.
+func Recovery(policy RecoverPolicy) Middleware {
+	return func(next Handler) Handler {
+		return func(job *Job) (rets []interface{}, err error) {
+			defer func() {
+				if info := recover(); info != nil {
+					rets, err = nil, policy(job, info)
+				}
+			}()
+			return next(job)
+		}
+	}
+}
+
+// Report the panic and finish the job as succeeded, it's neither retried nor dead lettered.
+func RecoverSkip(job *Job, info interface{}) error {
+	basic.Report(_REPORT_SOURCE, basic.PanicError(info), job.report())
+	return nil
+}
+
+// Report the panic and fail the job without retry.
+func RecoverFail(job *Job, info interface{}) error {
+	err := basic.PanicError(info)
+	basic.Report(_REPORT_SOURCE, err, job.report())
+	job.Msg.Retry = nil
+	return err
+}
+
+// Start a span for each job, the span ends with the error or the panic.
+func Tracing(tracer Tracer) Middleware {
+	return func(next Handler) Handler {
+		return func(job *Job) (rets []interface{}, err error) {
+			span := tracer.Start(job)
+			defer func() {
+				if info := recover(); info != nil {
+					span.End(basic.PanicError(info))
+					panic(info)
+				}
+				span.End(err)
+			}()
+			return next(job)
+		}
+	}
+}
+
+// Replace the parameters of the function at the indexes in logs and reports,
+// it must be before the middlewares which log the parameters.
+func Redact(name string, indexes ...int) Middleware {
+	return func(next Handler) Handler {
+		return func(job *Job) ([]interface{}, error) {
+			if job.Name() == name {
+				args := make([]interface{}, len(job.Args))
+				copy(args, job.Args)
+				for _, i := range indexes {
+					if i >= 0 && i < len(args) {
+						args[i] = REDACTED
+					}
+				}
+				job.Args = args
+			}
+			return next(job)
+		}
+	}
+}
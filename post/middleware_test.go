@@ -0,0 +1,152 @@
+package post
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/TianQinS/fastapi/basic"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordTracer struct {
+	lock  sync.Mutex
+	names []string
+	errs  []error
+}
+
+type recordSpan struct {
+	tracer *recordTracer
+	name   string
+}
+
+func (this *recordTracer) Start(job *Job) Span {
+	return &recordSpan{tracer: this, name: job.Name()}
+}
+
+func (this *recordSpan) End(err error) {
+	defer this.tracer.lock.Unlock()
+	this.tracer.lock.Lock()
+	this.tracer.names = append(this.tracer.names, this.name)
+	this.tracer.errs = append(this.tracer.errs, err)
+}
+
+func mark(lock *sync.Mutex, order *[]string, name string) Middleware {
+	return func(next Handler) Handler {
+		return func(job *Job) ([]interface{}, error) {
+			lock.Lock()
+			*order = append(*order, name)
+			lock.Unlock()
+			return next(job)
+		}
+	}
+}
+
+func TestMiddlewareChain(t *testing.T) {
+	var lock sync.Mutex
+	var order []string
+	p := NewPost(uint64(64), 1, WithMiddleware(mark(&lock, &order, "a"), mark(&lock, &order, "b")))
+	defer p.Close()
+	p.Use(mark(&lock, &order, "c"))
+	_, err := p.Submit(func() {}).Wait(time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, order)
+
+	// the objects added later have the same chain.
+	order = nil
+	o := p.AddOne()
+	_, err = o.Submit(func() {}).Wait(time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, order)
+
+	// the handler fails the job without calling the function.
+	called := false
+	p.Use(func(next Handler) Handler {
+		return func(job *Job) ([]interface{}, error) {
+			return nil, errors.New("denied")
+		}
+	})
+	_, err = p.Submit(func() { called = true }).Wait(time.Second)
+	assert.Equal(t, "denied", err.Error())
+	assert.False(t, called)
+}
+
+func TestMiddlewareRecovery(t *testing.T) {
+	ring := basic.NewRingReporter(8)
+	basic.SetReporter(ring)
+	defer basic.SetReporter(nil)
+
+	p := NewPost(uint64(64), 1, WithMiddleware(Recovery(RecoverSkip)))
+	defer p.Close()
+	p.SetDeadLetters(NewDeadLetters(0))
+	rets, err := p.Submit(func() int { panic("bad") }).Wait(time.Second)
+	assert.Nil(t, err)
+	assert.Nil(t, rets)
+	assert.Equal(t, 0, p.DeadLetters().Len())
+	assert.Equal(t, 1, ring.Len())
+
+	// the job isn't retried.
+	var runs int32
+	obj := newOverflowObject(OVERFLOW_FAIL, 0)
+	obj.Use(Recovery(RecoverFail))
+	msg := obj.newMsg(func() { atomic.AddInt32(&runs, 1); panic("bad") }, nil, nil, nil, false)
+	msg.Retry = &RetryPolicy{MaxAttempts: 3}
+	msg.requeue = obj.putMsg
+	future := NewFuture()
+	msg.Future = future
+	assert.Nil(t, obj.putMsg(msg))
+	obj.ExecuteEvent()
+	_, err = future.Wait(time.Second)
+	assert.Equal(t, "bad", err.Error())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&runs))
+	assert.Equal(t, uint64(0), obj.Stats().Retried)
+}
+
+func TestMiddlewareTracing(t *testing.T) {
+	ring := basic.NewRingReporter(8)
+	basic.SetReporter(ring)
+	defer basic.SetReporter(nil)
+
+	tracer := &recordTracer{}
+	var elapsed int64
+	obj := newOverflowObject(OVERFLOW_FAIL, 0)
+	obj.Register("login", func(user, password string) {
+		if password != "secret" {
+			panic("bad password")
+		}
+	})
+	obj.Use(Redact("login", 1), Tracing(tracer), Timing(func(job *Job, d time.Duration) {
+		atomic.AddInt64(&elapsed, int64(d))
+	}), SlowLog(0))
+	obj.PutQueue("login", false, "tom", "secret")
+	obj.PutQueue("login", false, "tom", "guess")
+	obj.ExecuteEvent()
+
+	assert.Equal(t, []string{"login", "login"}, tracer.names)
+	assert.Nil(t, tracer.errs[0])
+	assert.Contains(t, tracer.errs[1].Error(), "bad password")
+	assert.True(t, atomic.LoadInt64(&elapsed) > 0)
+	// the password is redacted in the report.
+	reports := ring.List()
+	assert.Equal(t, 1, len(reports))
+	assert.Equal(t, []interface{}{"tom", REDACTED}, reports[0].Args.(map[string]interface{})["params"])
+}
+
+func TestMiddlewareGroup(t *testing.T) {
+	var lock sync.Mutex
+	var order []string
+	p := NewPost(uint64(64), 1)
+	defer p.Close()
+	p.UseGroup("middleware", mark(&lock, &order, "a"))
+	p.UseGroup("middleware", mark(&lock, &order, "b"))
+	done := make(chan struct{})
+	assert.Nil(t, p.PutJob("middleware", func() { close(done) }))
+	<-done
+	time.Sleep(2 * MAX_SLEEP_TIME)
+	lock.Lock()
+	assert.Equal(t, []string{"a", "b"}, order)
+	lock.Unlock()
+	CloseGroup("middleware")
+}
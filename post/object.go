@@ -1,19 +1,31 @@
 package post
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"reflect"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/TianQinS/fastapi/basic"
 )
 
 const (
-	// max sleep time in ms.
+	// the default interval to poll the queue while the loop is parked.
 	MAX_SLEEP_TIME = 10000 * time.Microsecond
+	// the source of reports by the jobs.
+	_REPORT_SOURCE = "post"
+	// the parameters of small arities are kept in the message without allocation.
+	QUEUE_MSG_PARAM_NUM = 4
+)
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	// the messages of job workers.
+	jobMsgPool sync.Pool
 )
 
 type QueueMsg struct {
@@ -22,179 +34,545 @@ type QueueMsg struct {
 	Params          []interface{}
 	CallbackParams  []interface{}
 	StrictUnReflect bool
+	// the job will be skipped if the context is done before execution.
+	Ctx context.Context
+	// resolved with the return values or the recovered panic.
+	Future *Future
+	// the lane of the object for the job.
+	Priority Priority
+	// the job is rescheduled by the policy if it panics, Attempt is the number of failed attempts.
+	Retry   *RetryPolicy
+	Attempt int
+	requeue func(msg *QueueMsg) error
+	// the budget of execution, ctx is done after it if the function accepts a context.Context.
+	Timeout time.Duration
+	// the job is removed from the journal after it's completed.
+	journal   *Journal
+	journalID uint64
+	// the limiter is released after the job is finished.
+	limiter *limiter
+	// the span of the job, it's a child of the trace in Ctx if any.
+	Trace    *TraceContext
+	enqueued int64
+	// the storage of Params for small arities and the job passed to the middlewares.
+	params [QUEUE_MSG_PARAM_NUM]interface{}
+	job    Job
 }
 
+// A job which is called with the object executing it, such as the drain job of mailboxes.
+type objectJob func(o *RpcObject)
+
 type RpcObject struct {
-	Functions map[string]interface{}
+	Functions *Registry
 	// high performance lock-free queue, better performance than Chan at high load.
 	Queue *basic.EsQueue
 	// for batch extraction of queue data.
-	Vals     []interface{}
-	IsRun    bool
+	Vals []interface{}
+	// 1 while the loop should keep running, see IsRunning.
+	isRun    int32
 	itemPool sync.Pool
+	overflow overflow
+	// new jobs are rejected with ErrClosed after closed.
+	closed  int32
+	putting int64
+	// the all-or-nothing batches exclude the other senders while the space is reserved.
+	sendLock sync.RWMutex
+	// only one loop is running for the object.
+	running int32
+	// jobs taken out of the queue but not executed yet.
+	batched int64
+	stats   counters
+	// the queues by priority, Queue is the normal one.
+	lanes    [PRIORITY_NUM]*basic.EsQueue
+	laneVals []interface{}
+	drain    DrainPolicy
+	weights  [PRIORITY_NUM]int
+	// the failed jobs are kept in the dead letters if any.
+	dead atomic.Pointer[DeadLetters]
+	// the job running in the loop.
+	current current
+	wakeup  wakeup
+	// the middlewares around the execution of jobs.
+	chain atomic.Pointer[chain]
+	// the idle loop steals jobs from the siblings if it's set.
+	stealer atomic.Pointer[stealer]
+	// the spec object of Post.
+	spec bool
 }
 
+// Init the message for reuse, params are copied so that the message doesn't refer to the caller's slice.
 func (this *QueueMsg) Init(f, cb interface{}, params, cbParams []interface{}, strict bool) {
 	this.Func = f
 	this.Callback = cb
-	this.Params = params
+	this.Params = nil
+	if len(params) > 0 {
+		this.Params = append(this.params[:0], params...)
+	}
 	this.CallbackParams = cbParams
 	this.StrictUnReflect = strict
+	this.Ctx = nil
+	this.Future = nil
+	this.Priority = PRIORITY_NORMAL
+	this.Retry = nil
+	this.Attempt = 0
+	this.requeue = nil
+	this.journal = nil
+	this.journalID = 0
+	this.Timeout = 0
+	this.limiter = nil
+	this.Trace = nil
+	this.enqueued = 0
+}
+
+// Copy the message with its own parameters, the copy is kept after the message is recycled.
+func (this *QueueMsg) clone() QueueMsg {
+	msg := *this
+	msg.Params = append([]interface{}(nil), this.Params...)
+	msg.params = [QUEUE_MSG_PARAM_NUM]interface{}{}
+	msg.job = Job{}
+	return msg
+}
+
+// Resolve the future of the message if any.
+func (this *QueueMsg) resolve(rets []interface{}, err error) {
+	this.release()
+	if this.Future != nil {
+		this.Future.resolve(rets, err)
+	}
+}
+
+// Check if the job is cancelled before execution.
+func (this *QueueMsg) cancelled() bool {
+	return this.Ctx != nil && this.Ctx.Err() != nil
+}
+
+// Call the function of the message, the context will be passed in as the first parameter
+// when the function accepts it in reflect mode, it's done after the timeout if any.
+// The return values are only collected for the future.
+func (this *QueueMsg) call(function interface{}) (res []interface{}) {
+	// typed jobs are enqueued as closures which can be called without reflect,
+	// the callback of a plain function is called with reflect below.
+	if fn, ok := function.(func()); ok && this.Callback == nil {
+		fn()
+		return
+	}
+	if this.StrictUnReflect {
+		function.(func(args ...interface{}))(this.Params...)
+		return
+	}
+
+	_f := reflect.ValueOf(function)
+	in := make([]reflect.Value, 0, len(this.Params)+1)
+	if acceptContext(_f.Type()) {
+		ctx := this.Ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		if this.Trace != nil {
+			// the jobs put with ctx are the children of this one.
+			ctx = WithTrace(ctx, this.Trace)
+		}
+		if this.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, this.Timeout)
+			defer cancel()
+		}
+		in = append(in, reflect.ValueOf(ctx))
+	}
+	for k := range this.Params {
+		val := reflect.ValueOf(this.Params[k])
+		if !val.IsValid() {
+			// a nil parameter is the zero value of the type.
+			val = reflect.Zero(inType(_f.Type(), len(in)))
+		}
+		in = append(in, val)
+	}
+
+	rets := _f.Call(in)
+	if this.Future != nil {
+		res = valuesToInterfaces(rets)
+	}
+	// process callback logic.
+	if cb := this.Callback; cb != nil {
+		_f = reflect.ValueOf(cb)
+		params := this.CallbackParams
+		if params != nil {
+			for k := range params {
+				rets = append([]reflect.Value{reflect.ValueOf(params[k])}, rets...)
+			}
+		}
+		this.callback(func() {
+			_f.Call(rets)
+		})
+	}
+	return
+}
+
+// The type of the i-th parameter of the function.
+func inType(typ reflect.Type, i int) reflect.Type {
+	if typ.IsVariadic() && i >= typ.NumIn()-1 {
+		return typ.In(typ.NumIn() - 1).Elem()
+	}
+	if i < typ.NumIn() {
+		return typ.In(i)
+	}
+	// too many parameters, it panics in call.
+	return typ.In(0)
+}
+
+// Check if the first parameter of the function is a context.Context.
+func acceptContext(typ reflect.Type) bool {
+	return typ.NumIn() > 0 && typ.In(0) == contextType
+}
+
+// Convert the recovered panic to an error and report it, the stack is returned.
+func panicError(info interface{}, job *Job) (err error, trace string) {
+	if s, ok := info.(string); ok {
+		err = fmt.Errorf("%s->%s", s, runtime.FuncForPC(reflect.ValueOf(job.Func).Pointer()).Name())
+	} else {
+		err = basic.PanicError(info)
+	}
+	return err, basic.Report(_REPORT_SOURCE, err, job.report()).Trace
+}
+
+// Run the job by the handler with panic recovery, cancelled jobs are skipped.
+// It returns a dead letter if the job fails and won't be retried.
+func runMsg(job *Job, handler Handler, stats *counters, cur *current) (letter *DeadLetter) {
+	msg := job.Msg
+	if msg.cancelled() {
+		atomic.AddUint64(&stats.failed, 1)
+		msg.complete()
+		msg.resolve(nil, msg.Ctx.Err())
+		return
+	}
+	var rets []interface{}
+	var err error
+	start := time.Now()
+	cur.begin(msg, start)
+	defer func() {
+		cur.end()
+		var trace string
+		info := recover()
+		if info != nil {
+			err, trace = panicError(info, job)
+		} else if err != nil {
+			atomic.AddUint64(&stats.failed, 1)
+		}
+		stats.observe(time.Now().Sub(start), info != nil)
+		if msg.Trace != nil {
+			job.export(start, err)
+		}
+		if err != nil && msg.retry(err, stats) {
+			return
+		}
+		if err != nil {
+			letter = newDeadLetter(msg, err, trace)
+		}
+		msg.complete()
+		msg.resolve(rets, err)
+	}()
+	rets, err = handler(job)
+	return
 }
 
 func (this *RpcObject) Init(qSize uint64) {
-	this.Functions = map[string]interface{}{}
+	this.Functions = NewRegistry()
 	this.Queue = basic.NewQueue(qSize)
 	this.Vals = make([]interface{}, qSize, qSize)
-	this.IsRun = true
+	this.initLanes(qSize)
+	this.wakeup.init()
+	atomic.StoreInt32(&this.isRun, 1)
+}
+
+func (this *RpcObject) IsRunning() bool {
+	return atomic.LoadInt32(&this.isRun) == 1
+}
+
+// Ask the loop to exit after the current batch, the parked loop is woken up.
+func (this *RpcObject) stop() {
+	atomic.StoreInt32(&this.isRun, 0)
+	this.wakeup.notify()
+}
+
+// Wait until the loop exits or ctx is done, it returns at once if it's called by the loop itself.
+func (this *RpcObject) waitLoop(ctx context.Context) error {
+	if atomic.LoadInt32(&this.running) == 0 || goroutineID() == atomic.LoadInt64(&this.current.gid) {
+		return nil
+	}
+	for atomic.LoadInt32(&this.running) == 1 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return nil
 }
 
 // Register functions for object, f can be any function type,
-// but must be an `func(args ...interface{})` type in strict mode without reflect.
+// but must be an `func(args ...interface{})` type in strict mode without reflect,
+// and the args are reused by other jobs after it returns in strict mode.
+// The registered function is overwritten, use Functions.Replace to check the signature for a hotfix.
 func (this *RpcObject) Register(id string, f interface{}) {
-	if _, ok := this.Functions[id]; ok {
-		log.Println(fmt.Sprintf("function id %v: already registered", id))
+	if _, ok := this.Functions.Get(id); ok {
+		log.Printf("function id %v: already registered\n", id)
+	}
+	if _, err := this.Functions.Set(id, f); err != nil {
+		log.Println(err)
 	}
-	this.Functions[id] = f
 }
 
-func (this *RpcObject) newMsg(f, cb interface{}, params, cbParams []interface{}, strict bool) *QueueMsg {
-	item, ok := this.itemPool.Get().(*QueueMsg)
-	if ok {
-		item.Init(f, cb, params, cbParams, strict)
-		return item
-	}
-	return &QueueMsg{
-		Func:            f,
-		Callback:        cb,
-		Params:          params,
-		CallbackParams:  cbParams,
-		StrictUnReflect: strict,
+// Get a message from the pool.
+func acquireMsg(pool *sync.Pool, f, cb interface{}, params, cbParams []interface{}, strict bool) *QueueMsg {
+	item, ok := pool.Get().(*QueueMsg)
+	if !ok {
+		item = &QueueMsg{}
 	}
+	item.Init(f, cb, params, cbParams, strict)
+	return item
+}
+
+// Clear the references of the message and put it back to the pool,
+// the message mustn't be used any more, see clone to keep a copy.
+func recycleMsg(pool *sync.Pool, item *QueueMsg) {
+	*item = QueueMsg{}
+	pool.Put(item)
+}
+
+func (this *RpcObject) newMsg(f, cb interface{}, params, cbParams []interface{}, strict bool) *QueueMsg {
+	return acquireMsg(&this.itemPool, f, cb, params, cbParams, strict)
 }
 
 func (this *RpcObject) releaseMsg(item *QueueMsg) {
-	this.itemPool.Put(item)
+	recycleMsg(&this.itemPool, item)
+}
+
+// A message of job workers.
+func newJobMsg(f, cb interface{}, params, cbParams []interface{}, strict bool) *QueueMsg {
+	return acquireMsg(&jobMsgPool, f, cb, params, cbParams, strict)
+}
+
+func (this *RpcObject) put(ctx context.Context, f, cb interface{}, strictUnReflect bool, cbParams, params []interface{}) error {
+	msg := this.newMsg(f, cb, params, cbParams, strictUnReflect)
+	msg.Ctx = ctx
+	return this.putMsg(msg)
 }
 
 func (this *RpcObject) PutQueue(f interface{}, strictUnReflect bool, params ...interface{}) error {
-	ok, quantity := this.Queue.Put(this.newMsg(f, nil, params, nil, strictUnReflect))
-	if !ok {
-		return fmt.Errorf("Put Fail, quantity:%v\n", quantity)
+	return this.put(nil, f, nil, strictUnReflect, nil, params)
+}
+
+// The job will be skipped if ctx is done before execution,
+// and ctx is passed in if the first parameter of f is a context.Context.
+func (this *RpcObject) PutQueueCtx(ctx context.Context, f interface{}, strictUnReflect bool, params ...interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
-	return nil
+	return this.put(ctx, f, nil, strictUnReflect, nil, params)
 }
 
-func (this *RpcObject) PutQueueWithCallback(f, cb interface{}, strictUnReflect bool, cbParams, params []interface{}) error {
-	ok, quantity := this.Queue.Put(this.newMsg(f, cb, params, cbParams, strictUnReflect))
-	// fmt.Println(ok, f, cb, quantity, params)
-	if !ok {
-		return fmt.Errorf("Put Fail, quantity:%v\n", quantity)
+// Submit a job in reflect mode, the future is resolved when the job is finished.
+func (this *RpcObject) Submit(f interface{}, params ...interface{}) *Future {
+	return this.submit(nil, f, params)
+}
+
+func (this *RpcObject) submit(ctx context.Context, f interface{}, params []interface{}) *Future {
+	future := NewFuture()
+	msg := this.newMsg(f, nil, params, nil, false)
+	msg.Ctx = ctx
+	msg.Future = future
+	if err := this.putMsg(msg); err != nil {
+		future.resolve(nil, err)
 	}
-	return nil
+	return future
+}
+
+func (this *RpcObject) PutQueueWithCallback(f, cb interface{}, strictUnReflect bool, cbParams, params []interface{}) error {
+	return this.put(nil, f, cb, strictUnReflect, cbParams, params)
 }
 
 func (this *RpcObject) PutQueueForPost(f interface{}, strictUnReflect bool, params []interface{}) error {
-	ok, quantity := this.Queue.Put(this.newMsg(f, nil, params, nil, strictUnReflect))
-	if !ok {
-		return fmt.Errorf("Put Fail, quantity:%v\n", quantity)
-	}
-	return nil
+	return this.put(nil, f, nil, strictUnReflect, nil, params)
 }
 
-func (this *RpcObject) executeEvent(cnt uint64, vals *[]interface{}) {
-	var ok bool
-	var function interface{}
-LOOP:
+func (this *RpcObject) executeEvent(cnt uint64, vals *[]interface{}, lane Priority) {
+	if cnt > 0 {
+		this.notifySpace()
+	}
+	strict := lane != PRIORITY_HIGH && this.drain == DRAIN_STRICT
 	for i := uint64(0); i < cnt; i++ {
+		if strict {
+			this.preempt(lane)
+		}
+		atomic.StoreInt64(&this.batched, int64(cnt-i-1))
 		val := (*vals)[i]
-		msg := val.(*QueueMsg)
-		f := msg.Func
-
-		switch f.(type) {
-		case string:
-			if function, ok = this.Functions[f.(string)]; !ok {
-				log.Printf("Remote function(%v) not found\n", f)
-				continue LOOP
-			}
-		default:
-			function = f
-		}
-		_runFunc := func() {
-			defer func() {
-				info := recover()
-				switch info.(type) {
-				case error:
-					basic.PackErrorMsg(info.(error), msg)
-				case string:
-					basic.PackErrorMsg(fmt.Errorf("%s->%s", info.(string), runtime.FuncForPC(reflect.ValueOf(function).Pointer()).Name()), fmt.Sprintf("%+v", msg))
-				default:
-					if info != nil {
-						basic.PackErrorMsg(fmt.Errorf("%+v", info), msg)
-					}
-				}
-			}()
-
-			if msg.StrictUnReflect {
-				function.(func(args ...interface{}))(msg.Params...)
-			} else {
-				_f := reflect.ValueOf(function)
-				in := make([]reflect.Value, len(msg.Params))
-				for k := range in {
-					in[k] = reflect.ValueOf(msg.Params[k])
-				}
+		(*vals)[i] = nil
+		this.executeMsg(val.(*QueueMsg))
+	}
+}
 
-				rets := _f.Call(in)
-				// fmt.Println(cnt, msg.Func, msg.Params, rets)
-				// process callback logic.
-				if cb := msg.Callback; cb != nil {
-					_f = reflect.ValueOf(cb)
-					params := msg.CallbackParams
-					if params != nil {
-						for k := range params {
-							rets = append([]reflect.Value{reflect.ValueOf(params[k])}, rets...)
-						}
-					}
-					_f.Call(rets)
-				}
-			}
+func (this *RpcObject) executeMsg(msg *QueueMsg) {
+	var function interface{}
+	f := msg.Func
+
+	switch f.(type) {
+	case string:
+		var err error
+		var params []interface{}
+		entry, ok := this.Functions.entry(f.(string))
+		if !ok {
+			log.Printf("Remote function(%v) not found\n", f)
+			err = fmt.Errorf("Remote function(%v) not found", f)
+		} else if params, err = entry.Signature.check(entry.ID, msg.Params, this.Functions.coercion()); err != nil {
+			log.Println(err)
+		}
+		if err != nil {
+			atomic.AddUint64(&this.stats.failed, 1)
+			this.deadLetter(newDeadLetter(msg, err, ""))
+			msg.complete()
+			msg.resolve(nil, err)
+			this.releaseMsg(msg)
+			return
 		}
-		_runFunc()
+		function = entry.Func
+		msg.Params = params
+	case objectJob:
+		fn := f.(objectJob)
+		function = func() {
+			fn(this)
+		}
+	default:
+		function = f
+	}
+	job := &msg.job
+	*job = Job{Msg: msg, Func: function, Args: msg.Params}
+	if letter := runMsg(job, this.chain.Load().get(), &this.stats, &this.current); letter != nil {
+		this.deadLetter(letter)
 	}
+	// the retries and dead letters are copies.
+	this.releaseMsg(msg)
 }
 
 // Can only be executed in one gorountine.
 // This function returns number of events which can be used for dynamic sleep.
 func (this *RpcObject) ExecuteEvent() uint64 {
-	cnt, _ := this.Queue.Gets(this.Vals)
-	this.executeEvent(cnt, &this.Vals)
-	return cnt
+	return this.executeLanes(this.Vals)
 }
 
 // Can be executed concurrently but not commonly used.
-func (this *RpcObject) ExecuteEventSafe() uint64 {
-	qSize := this.Queue.Quantity()
-	vals := make([]interface{}, 2*qSize)
-	cnt, _ := this.Queue.Gets(vals)
-	this.executeEvent(cnt, &vals)
-	return cnt
+func (this *RpcObject) ExecuteEventSafe() (total uint64) {
+	for _, lane := range laneOrder {
+		q := this.lanes[lane]
+		vals := make([]interface{}, 2*q.Quantity())
+		cnt, _ := q.Gets(vals)
+		this.executeEvent(cnt, &vals, PRIORITY_HIGH)
+		total += cnt
+	}
+	for _, msg := range this.takeSpill() {
+		this.executeMsg(msg)
+		total++
+	}
+	return
 }
 
-// The main loop of RpcObject.
+// The main loop of RpcObject, it returns immediately if the object has been running.
 func (this *RpcObject) Loop() {
-	for this.IsRun {
-		start := time.Now()
-		this.ExecuteEvent()
-		delta := MAX_SLEEP_TIME - time.Now().Sub(start)
-		if delta > 0 {
-			time.Sleep(delta)
-		} else {
-			runtime.Gosched()
+	if !atomic.CompareAndSwapInt32(&this.running, 0, 1) {
+		return
+	}
+	atomic.StoreInt64(&this.current.gid, goroutineID())
+	for {
+		for spin := 0; this.IsRunning(); {
+			if this.ExecuteEvent() > 0 || this.steal() > 0 {
+				spin = 0
+				continue
+			}
+			this.idle(spin)
+			spin++
+		}
+		atomic.StoreInt32(&this.running, 0)
+		// keep running if the object is reopened before the loop exits.
+		if !this.IsRunning() || !atomic.CompareAndSwapInt32(&this.running, 0, 1) {
+			return
+		}
+	}
+}
+
+// Take all the remaining jobs out of the queue.
+func (this *RpcObject) takeAll() []*QueueMsg {
+	msgs := make([]*QueueMsg, 0, this.quantity())
+	for this.quantity() > 0 {
+		if msg, ok := this.getOne(); ok {
+			msgs = append(msgs, msg)
 		}
 	}
+	return append(msgs, this.takeSpill()...)
 }
 
+// Drain the queue synchronously with rejecting new jobs.
 func (this *RpcObject) Close() {
-	this.IsRun = false
-	this.ExecuteEventSafe()
+	this.Shutdown(context.Background())
+}
+
+// Stop accepting new jobs and wait for the puts in progress.
+func (this *RpcObject) stopAccepting(ctx context.Context) {
+	atomic.StoreInt32(&this.closed, 1)
+	for atomic.LoadInt64(&this.putting) > 0 && ctx.Err() == nil {
+		runtime.Gosched()
+	}
+}
+
+// Accept new jobs again, it's used by Post for a reused object.
+func (this *RpcObject) reopen() {
+	atomic.StoreInt32(&this.closed, 0)
+	atomic.StoreInt32(&this.isRun, 1)
+}
+
+// Stop accepting new jobs and execute the remaining jobs in the calling goroutine after the loop exits,
+// until the queue is empty or ctx is done, the rest jobs are dropped when ctx is done.
+func (this *RpcObject) Shutdown(ctx context.Context) (dropped int, err error) {
+	this.stopAccepting(ctx)
+	this.stop()
+	// the jobs are executed serially, so the batch of the loop is finished first.
+	if err = this.waitLoop(ctx); err != nil {
+		return this.discard(), err
+	}
+	for {
+		if err = ctx.Err(); err != nil {
+			return this.discard(), err
+		}
+		if msg, ok := this.getOne(); ok {
+			this.executeMsg(msg)
+			continue
+		}
+		if msgs := this.takeSpill(); len(msgs) > 0 {
+			for i, msg := range msgs {
+				if err = ctx.Err(); err != nil {
+					for _, msg = range msgs[i:] {
+						this.dropMsg(msg, ErrClosed)
+					}
+					return len(msgs) - i + this.discard(), err
+				}
+				this.executeMsg(msg)
+			}
+			continue
+		}
+		if this.quantity() == 0 {
+			return
+		}
+	}
+}
+
+// Drop all the remaining jobs, it returns the number of them.
+func (this *RpcObject) discard() (dropped int) {
+	for this.quantity() > 0 || atomic.LoadInt64(&this.overflow.spilled) > 0 {
+		if msg, ok := this.getOne(); ok {
+			this.dropMsg(msg, ErrClosed)
+			dropped++
+		}
+		for _, msg := range this.takeSpill() {
+			this.dropMsg(msg, ErrClosed)
+			dropped++
+		}
+	}
+	return
 }
@@ -1,10 +1,13 @@
 package post
 
 import (
+	"context"
 	"runtime"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/TianQinS/fastapi/basic"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -24,7 +27,7 @@ func init() {
 		return
 	}
 	go func() {
-		for o.IsRun {
+		for o.IsRunning() {
 			n := o.ExecuteEvent()
 			n = 10 - n
 			if n > 0 {
@@ -61,6 +64,54 @@ func TestObject(t *testing.T) {
 	assert.Equal(t, 1, d2)
 }
 
+func TestObjectContext(t *testing.T) {
+	if benchTest {
+		return
+	}
+	var d1 int32
+	d2 := 0
+	ctx, cancel := context.WithCancel(context.Background())
+	err := o.PutQueueCtx(ctx, func(ctx context.Context, d *int32) {
+		if ctx.Err() == nil {
+			atomic.StoreInt32(d, 1)
+		}
+	}, false, &d1)
+	assert.Equal(t, err, nil)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&d1))
+
+	cancel()
+	err = o.PutQueueCtx(ctx, func2, false, &d2, "test")
+	assert.Equal(t, err, context.Canceled)
+	// cancelled after being queued.
+	ctx, cancel = context.WithCancel(context.Background())
+	err = o.PutQueueCtx(ctx, func2, false, &d2, "test")
+	assert.Equal(t, err, nil)
+	cancel()
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, 0, d2)
+}
+
+func TestObjectReport(t *testing.T) {
+	ring := basic.NewRingReporter(8)
+	basic.SetReporter(ring)
+	defer basic.SetReporter(nil)
+
+	obj := newOverflowObject(OVERFLOW_FAIL, 0)
+	obj.Register("panic", func(i int) {
+		panic(i)
+	})
+	future := obj.Submit("panic", 7)
+	obj.ExecuteEvent()
+	_, err := future.Wait(time.Second)
+	assert.Equal(t, "7", err.Error())
+	reports := ring.List()
+	assert.Equal(t, 1, len(reports))
+	assert.Equal(t, "post", reports[0].Source)
+	assert.Equal(t, "panic", reports[0].Args.(map[string]interface{})["func"])
+	assert.Contains(t, reports[0].Trace, "TestObjectReport")
+}
+
 func BenchmarkTest1(b *testing.B) {
 	d := 0
 	for i := 0; i < b.N; i++ {
@@ -76,3 +127,70 @@ func BenchmarkTest2(b *testing.B) {
 		o.ExecuteEvent()
 	}
 }
+
+// An object and a post whose loops are stopped, the events are executed by the benchmarks.
+func benchObjects() (*RpcObject, *Post) {
+	obj := &RpcObject{}
+	obj.Init(1024)
+	p := NewPost(uint64(1024), 1)
+	p.objects[0].stop()
+	time.Sleep(2 * MAX_SLEEP_TIME)
+	return obj, p
+}
+
+func TestObjectAllocs(t *testing.T) {
+	obj, p := benchObjects()
+	defer p.Close()
+	d := 0
+	// warm up the pools.
+	obj.PutQueue(func1, true, &d, "test")
+	obj.ExecuteEvent()
+	assert.Equal(t, 0.0, testing.AllocsPerRun(100, func() {
+		obj.PutQueue(func1, true, &d, "test")
+		obj.ExecuteEvent()
+	}))
+	assert.Equal(t, 0.0, testing.AllocsPerRun(100, func() {
+		p.PutQueueStrict(func1, &d, "test")
+		p.objects[0].ExecuteEvent()
+	}))
+	// the closure of a typed job.
+	assert.Equal(t, 1.0, testing.AllocsPerRun(100, func() {
+		Go(p, func2Typed, &d)
+		p.objects[0].ExecuteEvent()
+	}))
+}
+
+func BenchmarkPutQueueStrict(b *testing.B) {
+	obj, p := benchObjects()
+	defer p.Close()
+	d := 0
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		obj.PutQueue(func1, true, &d, "test")
+		obj.ExecuteEvent()
+	}
+}
+
+func BenchmarkPostStrict(b *testing.B) {
+	_, p := benchObjects()
+	defer p.Close()
+	obj := p.objects[0]
+	d := 0
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		p.PutQueueStrict(func1, &d, "test")
+		obj.ExecuteEvent()
+	}
+}
+
+func BenchmarkPostTyped(b *testing.B) {
+	_, p := benchObjects()
+	defer p.Close()
+	obj := p.objects[0]
+	d := 0
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Go(p, func2Typed, &d)
+		obj.ExecuteEvent()
+	}
+}
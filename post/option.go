@@ -0,0 +1,59 @@
+package post
+
+import (
+	"time"
+)
+
+// Option configures the Post created by NewPost.
+type Option func(*Post)
+
+// Set the overflow policy of the queues, see OverflowPolicy.
+func WithOverflow(policy OverflowPolicy, timeout time.Duration) Option {
+	return func(p *Post) {
+		p.overflow = policy
+		p.overflowTimeout = timeout
+	}
+}
+
+// Start the autoscaler for the Post, see ScaleConfig.
+func WithAutoScale(config ScaleConfig) Option {
+	return func(p *Post) {
+		p.scaleConfig = &config
+	}
+}
+
+// Set the strategy to dispatch jobs to the objects, RoundRobin is the default.
+func WithDispatcher(dispatcher Dispatcher) Option {
+	return func(p *Post) {
+		p.dispatcher = dispatcher
+	}
+}
+
+// Set the drain policy of priority lanes, see RpcObject.SetDrainPolicy.
+func WithDrainPolicy(policy DrainPolicy, weights [PRIORITY_NUM]int) Option {
+	return func(p *Post) {
+		p.drain = policy
+		p.weights = weights
+	}
+}
+
+// Set the interval to poll the queue while the loop of object is parked, see RpcObject.SetPollInterval.
+func WithPollInterval(interval time.Duration) Option {
+	return func(p *Post) {
+		p.poll = interval
+	}
+}
+
+// Enable work stealing between the objects, see Post.SetWorkStealing.
+func WithWorkStealing(config StealConfig) Option {
+	return func(p *Post) {
+		p.stealConfig = &config
+	}
+}
+
+// Set the middlewares of all objects, see Post.Use.
+func WithMiddleware(middlewares ...Middleware) Option {
+	return func(p *Post) {
+		p.middlewares = append(p.middlewares, middlewares...)
+	}
+}
@@ -0,0 +1,222 @@
+// Overflow policies for the queues of objects.
+package post
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// return ErrQueueFull immediately, the default policy.
+	OVERFLOW_FAIL OverflowPolicy = iota
+	// block until there is space in the queue or timeout.
+	OVERFLOW_BLOCK
+	// retry with spin and exponential backoff until timeout.
+	OVERFLOW_RETRY
+	// drop the oldest job in the queue to make room for the new one.
+	OVERFLOW_DROP_OLDEST
+	// drop the new job silently.
+	OVERFLOW_DROP_NEWEST
+	// keep the jobs in an unbounded overflow slice until the queue is drained.
+	OVERFLOW_SPILL
+)
+
+const (
+	// spin times before backoff in OVERFLOW_RETRY.
+	OVERFLOW_SPIN_NUM = 16
+	// the maximum backoff of OVERFLOW_RETRY.
+	OVERFLOW_MAX_BACKOFF = MAX_SLEEP_TIME
+)
+
+var (
+	ErrQueueFull = errors.New("post: queue is full")
+)
+
+type OverflowPolicy int32
+
+// The overflow slice and waiters for space of an object.
+type overflow struct {
+	policy  int32
+	timeout int64
+	// the number of jobs in spill.
+	spilled   int64
+	spill     []*QueueMsg
+	spillLock sync.Mutex
+	// waiters of OVERFLOW_BLOCK are notified when the queue is drained.
+	waiters   int32
+	space     chan struct{}
+	spaceLock sync.Mutex
+}
+
+func queueFullError(quantity uint64) error {
+	return fmt.Errorf("Put Fail, quantity:%v: %w", quantity, ErrQueueFull)
+}
+
+// Set the overflow policy, a non-positive timeout means waiting forever for OVERFLOW_BLOCK and OVERFLOW_RETRY.
+func (this *RpcObject) SetOverflow(policy OverflowPolicy, timeout time.Duration) {
+	atomic.StoreInt32(&this.overflow.policy, int32(policy))
+	atomic.StoreInt64(&this.overflow.timeout, int64(timeout))
+}
+
+// Put the message into the queue, new jobs are rejected after closed.
+func (this *RpcObject) putMsg(msg *QueueMsg) (err error) {
+	atomic.AddInt64(&this.putting, 1)
+	defer atomic.AddInt64(&this.putting, -1)
+	if atomic.LoadInt32(&this.closed) == 1 {
+		err = ErrClosed
+	} else {
+		msg.trace()
+		err = this.enqueue(msg)
+	}
+	if err != nil {
+		atomic.AddUint64(&this.stats.putFailed, 1)
+	}
+	return
+}
+
+// Put the message into the queue according to the overflow policy.
+func (this *RpcObject) enqueue(msg *QueueMsg) error {
+	// keep the order when the overflow slice is not empty.
+	if atomic.LoadInt64(&this.overflow.spilled) > 0 && this.spillMsg(msg, false) {
+		return nil
+	}
+
+	var deadline time.Time
+	policy := OverflowPolicy(atomic.LoadInt32(&this.overflow.policy))
+	if timeout := time.Duration(atomic.LoadInt64(&this.overflow.timeout)); timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	backoff := time.Microsecond
+	q := this.lane(msg.Priority)
+	for spin := 0; ; spin++ {
+		this.sendLock.RLock()
+		ok, quantity := q.Put(msg)
+		this.sendLock.RUnlock()
+		if ok {
+			atomic.AddUint64(&this.stats.enqueued, 1)
+			this.wake()
+			return nil
+		}
+		if quantity+2 < q.Capacity() {
+			// a collision with other producers rather than a full queue.
+			continue
+		}
+
+		switch policy {
+		case OVERFLOW_BLOCK:
+			if !this.waitSpace(deadline) {
+				return queueFullError(quantity)
+			}
+		case OVERFLOW_RETRY:
+			if !deadline.IsZero() && time.Now().After(deadline) {
+				return queueFullError(quantity)
+			}
+			if spin < OVERFLOW_SPIN_NUM {
+				runtime.Gosched()
+			} else {
+				time.Sleep(backoff)
+				if backoff *= 2; backoff > OVERFLOW_MAX_BACKOFF {
+					backoff = OVERFLOW_MAX_BACKOFF
+				}
+			}
+		case OVERFLOW_DROP_OLDEST:
+			if val, ok, _ := q.Get(); ok {
+				this.dropMsg(val.(*QueueMsg), ErrQueueFull)
+			}
+		case OVERFLOW_DROP_NEWEST:
+			this.dropMsg(msg, ErrQueueFull)
+			return nil
+		case OVERFLOW_SPILL:
+			this.spillMsg(msg, true)
+			return nil
+		default:
+			return queueFullError(quantity)
+		}
+	}
+}
+
+// The future of a dropped job is resolved with the error.
+func (this *RpcObject) dropMsg(msg *QueueMsg, err error) {
+	atomic.AddUint64(&this.stats.dropped, 1)
+	// the jobs discarded by shutdown are kept in the journal for the next start.
+	if err != ErrClosed {
+		msg.complete()
+	}
+	msg.resolve(nil, err)
+}
+
+// Append the message to the overflow slice, it fails if the slice is empty and force is false.
+func (this *RpcObject) spillMsg(msg *QueueMsg, force bool) bool {
+	defer this.overflow.spillLock.Unlock()
+	this.overflow.spillLock.Lock()
+	if !force && len(this.overflow.spill) == 0 {
+		return false
+	}
+	this.overflow.spill = append(this.overflow.spill, msg)
+	atomic.AddInt64(&this.overflow.spilled, 1)
+	atomic.AddUint64(&this.stats.enqueued, 1)
+	this.wake()
+	return true
+}
+
+// Take all the messages in the overflow slice.
+func (this *RpcObject) takeSpill() []*QueueMsg {
+	if atomic.LoadInt64(&this.overflow.spilled) == 0 {
+		return nil
+	}
+	defer this.overflow.spillLock.Unlock()
+	this.overflow.spillLock.Lock()
+	msgs := this.overflow.spill
+	this.overflow.spill = nil
+	atomic.StoreInt64(&this.overflow.spilled, 0)
+	return msgs
+}
+
+// Wait until the queue is drained by the consumer, it returns false if deadline exceeded.
+func (this *RpcObject) waitSpace(deadline time.Time) bool {
+	wait := MAX_SLEEP_TIME
+	if !deadline.IsZero() {
+		remain := deadline.Sub(time.Now())
+		if remain <= 0 {
+			return false
+		}
+		if remain < wait {
+			wait = remain
+		}
+	}
+
+	this.overflow.spaceLock.Lock()
+	if this.overflow.space == nil {
+		this.overflow.space = make(chan struct{})
+	}
+	space := this.overflow.space
+	this.overflow.spaceLock.Unlock()
+
+	atomic.AddInt32(&this.overflow.waiters, 1)
+	defer atomic.AddInt32(&this.overflow.waiters, -1)
+	// the notification may be missed, so wait for a short time at most.
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-space:
+	case <-t.C:
+	}
+	return true
+}
+
+// Wake up all the waiters for space.
+func (this *RpcObject) notifySpace() {
+	if atomic.LoadInt32(&this.overflow.waiters) == 0 {
+		return
+	}
+	this.overflow.spaceLock.Lock()
+	if this.overflow.space != nil {
+		close(this.overflow.space)
+		this.overflow.space = nil
+	}
+	this.overflow.spaceLock.Unlock()
+}
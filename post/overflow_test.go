@@ -0,0 +1,86 @@
+package post
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// An object without loop, the capacity of it's queue is 16 and 14 jobs at most.
+func newOverflowObject(policy OverflowPolicy, timeout time.Duration) *RpcObject {
+	obj := &RpcObject{}
+	obj.Init(16)
+	obj.SetOverflow(policy, timeout)
+	return obj
+}
+
+func fillObject(obj *RpcObject, seq *[]int, num int) (err error) {
+	for i := 0; i < num; i++ {
+		if err = obj.PutQueue(func(i int) {
+			*seq = append(*seq, i)
+		}, false, i); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func TestOverflowFail(t *testing.T) {
+	seq := []int{}
+	obj := newOverflowObject(OVERFLOW_FAIL, 0)
+	err := fillObject(obj, &seq, 15)
+	assert.Equal(t, true, errors.Is(err, ErrQueueFull))
+
+	p := NewPost(uint64(16), 1)
+	p.DelOne()
+	assert.Equal(t, ErrNoWorkers, p.PutQueue(func() {}))
+	p.Close()
+}
+
+func TestOverflowDrop(t *testing.T) {
+	seq := []int{}
+	obj := newOverflowObject(OVERFLOW_DROP_NEWEST, 0)
+	assert.Equal(t, nil, fillObject(obj, &seq, 16))
+	obj.ExecuteEvent()
+	assert.Equal(t, 14, len(seq))
+	assert.Equal(t, 13, seq[13])
+
+	seq = seq[:0]
+	obj = newOverflowObject(OVERFLOW_DROP_OLDEST, 0)
+	assert.Equal(t, nil, fillObject(obj, &seq, 16))
+	obj.ExecuteEvent()
+	assert.Equal(t, 14, len(seq))
+	assert.Equal(t, 2, seq[0])
+	assert.Equal(t, 15, seq[13])
+}
+
+func TestOverflowSpill(t *testing.T) {
+	seq := []int{}
+	obj := newOverflowObject(OVERFLOW_SPILL, 0)
+	assert.Equal(t, nil, fillObject(obj, &seq, 30))
+	obj.ExecuteEvent()
+	assert.Equal(t, 30, len(seq))
+	for i := range seq {
+		assert.Equal(t, i, seq[i])
+	}
+}
+
+func TestOverflowBlock(t *testing.T) {
+	seq := []int{}
+	obj := newOverflowObject(OVERFLOW_BLOCK, 10*time.Millisecond)
+	err := fillObject(obj, &seq, 15)
+	assert.Equal(t, true, errors.Is(err, ErrQueueFull))
+
+	obj.SetOverflow(OVERFLOW_BLOCK, time.Second)
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		obj.ExecuteEvent()
+	}()
+	assert.Equal(t, nil, fillObject(obj, &seq, 1))
+
+	obj.SetOverflow(OVERFLOW_RETRY, 10*time.Millisecond)
+	err = fillObject(obj, &seq, 15)
+	assert.Equal(t, true, errors.Is(err, ErrQueueFull))
+}
@@ -2,9 +2,12 @@
 package post
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"log"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -16,34 +19,80 @@ const (
 
 var (
 	GPost *Post
+
+	ErrNoWorkers = errors.New("post: no running object")
+	ErrClosed    = errors.New("post: closed")
 )
 
 type Post struct {
 	objects   []*RpcObject
 	Object    *RpcObject
-	Functions map[string]interface{}
+	Functions *Registry
 	qSize     uint64
 	index     int
-	curIndex  int
 	lock      *sync.Mutex
+	// the snapshot of running objects for dispatching.
+	running    atomic.Value
+	dispatcher Dispatcher
+	closed     int32
+	// mailboxes for key-ordered jobs.
+	keyed *keyedRouter
+	// the overflow policy for the queues of objects.
+	overflow        OverflowPolicy
+	overflowTimeout time.Duration
+	scaler          *scaler
+	scaleConfig     *ScaleConfig
+	// the retry policies of registered functions.
+	retries sync.Map
+	dead    *DeadLetters
+	journal atomic.Pointer[Journal]
+	// the timeouts of registered functions.
+	timeouts sync.Map
+	watchdog *watchdog
+	// the limiters of registered functions.
+	limiters sync.Map
+	// the drain policy of priority lanes.
+	drain   DrainPolicy
+	weights [PRIORITY_NUM]int
+	// the interval to poll the queue while the loop of object is parked.
+	poll time.Duration
+	// the middlewares of all objects.
+	middlewares []Middleware
+	// work stealing between the objects, it's nil if disabled.
+	stealer     *stealer
+	stealConfig *StealConfig
+	// the all-or-nothing batches are serialized to lock the objects.
+	batchLock sync.Mutex
 }
 
 func init() {
 	GPost = NewPost(ITEM_QUEUE_CAPACITY, ORI_ROUTINE_NUM)
 }
 
-func NewPost(queueCapacity uint64, oriNum int) *Post {
+func NewPost(queueCapacity uint64, oriNum int, opts ...Option) *Post {
 	p := &Post{
 		index:     0,
-		curIndex:  0,
 		qSize:     queueCapacity,
 		objects:   make([]*RpcObject, 0, oriNum),
 		Object:    nil,
-		Functions: make(map[string]interface{}),
+		Functions: NewRegistry(),
 		lock:      new(sync.Mutex),
+		keyed:     newKeyedRouter(),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.dispatcher == nil {
+		p.dispatcher = RoundRobin()
+	}
+	if p.stealConfig != nil {
+		p.SetWorkStealing(p.stealConfig)
 	}
 	p.CreateSpecObject()
 	p.AddObjects(oriNum)
+	if p.scaleConfig != nil {
+		p.StartAutoScale(*p.scaleConfig)
+	}
 	return p
 }
 
@@ -51,12 +100,19 @@ func (this *Post) makeObject() *RpcObject {
 	o := &RpcObject{}
 	o.Init(this.qSize)
 	o.Functions = this.Functions
-	o.IsRun = true
+	o.SetOverflow(this.overflow, this.overflowTimeout)
+	o.SetDrainPolicy(this.drain, this.weights)
+	o.SetPollInterval(this.poll)
+	if len(this.middlewares) > 0 {
+		o.Use(this.middlewares...)
+	}
+	o.dead.Store(this.dead)
 	return o
 }
 
 func (this *Post) CreateSpecObject() {
 	o := this.makeObject()
+	o.spec = true
 	go o.Loop()
 	this.Object = o
 }
@@ -66,29 +122,45 @@ func (this *Post) Size() int {
 }
 
 func (this *Post) Register(id string, f interface{}) {
-	if _, ok := this.Functions[id]; ok {
-		log.Panicln(fmt.Sprintf("function id %v: already registered", id))
+	if err := this.Functions.Register(id, f); err != nil {
+		log.Panicln(err)
 	}
-	this.Functions[id] = f
 }
 
+// Set the overflow policy for all objects, it's used to configure GPost at runtime.
+func (this *Post) SetOverflow(policy OverflowPolicy, timeout time.Duration) {
+	defer this.lock.Unlock()
+	this.lock.Lock()
+	this.overflow = policy
+	this.overflowTimeout = timeout
+	for _, o := range this.objects {
+		o.SetOverflow(policy, timeout)
+	}
+	this.Object.SetOverflow(policy, timeout)
+}
+
+// Add a running object, it returns nil after the post is closed.
 func (this *Post) AddOne() *RpcObject {
 	defer this.lock.Unlock()
 	this.lock.Lock()
+	if atomic.LoadInt32(&this.closed) == 1 {
+		return nil
+	}
 
 	var o *RpcObject
 	if this.index < this.Size() && this.index >= 0 {
+		// reuse the retired object.
 		o = this.objects[this.index]
-		if !o.IsRun {
-			o.IsRun = true
-		}
+		o.reopen()
 	} else {
 		o = this.makeObject()
+		o.stealer.Store(this.stealer)
+		this.objects = append(this.objects, o)
 	}
 
 	go o.Loop()
-	this.objects = append(this.objects, o)
 	this.index++
+	this.refresh()
 	return o
 }
 
@@ -99,14 +171,34 @@ func (this *Post) AddObjects(num int) {
 	}
 }
 
+// Retire the last running object, it's pending jobs are migrated to the other objects.
 func (this *Post) DelOne() {
 	defer this.lock.Unlock()
 	this.lock.Lock()
 	index := this.index - 1
 	if index >= 0 {
 		o := this.objects[index]
-		o.Close()
 		this.index = index
+		this.refresh()
+		if index == 0 {
+			o.Close()
+			return
+		}
+		o.stopAccepting(context.Background())
+		o.stop()
+		var rest []*QueueMsg
+		for _, msg := range o.takeAll() {
+			if err := this.pick(msg.Func).putMsg(msg); err != nil {
+				rest = append(rest, msg)
+			}
+		}
+		if len(rest) > 0 {
+			// the jobs are executed serially after the batch of the loop.
+			o.waitLoop(context.Background())
+			for _, msg := range rest {
+				o.executeMsg(msg)
+			}
+		}
 	}
 }
 
@@ -114,46 +206,93 @@ func (this *Post) DelOne() {
 func (this *Post) Close() {
 	defer this.lock.Unlock()
 	this.lock.Lock()
-	if this.Size() > 0 {
-		for _, o := range this.objects {
-			this.index--
-			o.Close()
-		}
+	atomic.StoreInt32(&this.closed, 1)
+	this.stopScale()
+	this.stopWatchdog()
+	this.index = 0
+	this.refresh()
+	for _, o := range this.objects {
+		o.Close()
 	}
-	if this.Object.IsRun {
+	if this.Object.IsRunning() {
 		this.Object.Close()
 	}
 }
 
+// Stop accepting new jobs and drain all the queues until they're empty or ctx is done,
+// it returns the number of jobs dropped.
+func (this *Post) Shutdown(ctx context.Context) (dropped int, err error) {
+	defer this.lock.Unlock()
+	this.lock.Lock()
+	this.stopScale()
+	this.stopWatchdog()
+	objects := append([]*RpcObject{this.Object}, this.objects...)
+	atomic.StoreInt32(&this.closed, 1)
+	for _, o := range objects {
+		o.stopAccepting(ctx)
+	}
+	this.index = 0
+	this.refresh()
+	// the objects are drained concurrently, so a slow one doesn't use up the deadline of the others.
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	for _, o := range objects {
+		wg.Add(1)
+		go func(o *RpcObject) {
+			defer wg.Done()
+			n, e := o.Shutdown(ctx)
+			lock.Lock()
+			dropped += n
+			if err == nil {
+				err = e
+			}
+			lock.Unlock()
+		}(o)
+	}
+	wg.Wait()
+	return
+}
+
 // Call a function with routine pool in high load situations.
 func (this *Post) PutQueue(f interface{}, params ...interface{}) error {
-	index := this.index
-	if index > 0 {
-		this.curIndex++
-		seq := this.curIndex
-		if seq >= index {
-			seq = 0
-			this.curIndex = 0
-		}
-		o := this.objects[seq]
-		return o.PutQueueForPost(f, false, params)
+	return this.put(nil, PRIORITY_NORMAL, f, nil, false, nil, params)
+}
+
+// The job will be skipped if ctx is done before execution,
+// and ctx is passed in if the first parameter of f is a context.Context.
+func (this *Post) PutQueueCtx(ctx context.Context, f interface{}, params ...interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
-	return nil
+	return this.put(ctx, PRIORITY_NORMAL, f, nil, false, nil, params)
+}
+
+// Submit a job to routine pool, the future is resolved with the return values of f.
+func (this *Post) Submit(f interface{}, params ...interface{}) *Future {
+	return this.submit(nil, f, params)
+}
+
+func (this *Post) SubmitCtx(ctx context.Context, f interface{}, params ...interface{}) *Future {
+	if err := ctx.Err(); err != nil {
+		return rejectedFuture(err)
+	}
+	return this.submit(ctx, f, params)
 }
 
 func (this *Post) PutQueueWithCallback(f, cb interface{}, cbParams []interface{}, params ...interface{}) error {
-	index := this.index
-	if index > 0 {
-		this.curIndex++
-		seq := this.curIndex
-		if seq >= index {
-			seq = 0
-			this.curIndex = 0
-		}
-		o := this.objects[seq]
-		return o.PutQueueWithCallback(f, cb, false, cbParams, params)
+	return this.put(nil, PRIORITY_NORMAL, f, cb, false, cbParams, params)
+}
+
+func (this *Post) PutQueueWithCallbackCtx(ctx context.Context, f, cb interface{}, cbParams []interface{}, params ...interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
-	return nil
+	return this.put(ctx, PRIORITY_NORMAL, f, cb, false, cbParams, params)
+}
+
+// Put a closure which will be called without reflect.
+func (this *Post) putFunc(fn func()) error {
+	return this.put(nil, PRIORITY_NORMAL, fn, nil, false, nil, nil)
 }
 
 // Call a function in a special routine.
@@ -161,19 +300,23 @@ func (this *Post) PutQueueSpec(f interface{}, params ...interface{}) error {
 	return this.Object.PutQueueForPost(f, false, params)
 }
 
+func (this *Post) PutQueueSpecCtx(ctx context.Context, f interface{}, params ...interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return this.Object.put(ctx, f, nil, false, nil, params)
+}
+
 func (this *Post) PutQueueStrict(f interface{}, params ...interface{}) error {
-	index := this.index
-	if index > 0 {
-		this.curIndex++
-		seq := this.curIndex
-		if seq >= index {
-			seq = 0
-			this.curIndex = 0
-		}
-		o := this.objects[seq]
-		return o.PutQueueForPost(f, true, params)
+	return this.put(nil, PRIORITY_NORMAL, f, nil, true, nil, params)
+}
+
+// The context can't be passed in for strict mode, but the job will be skipped if ctx is done.
+func (this *Post) PutQueueStrictCtx(ctx context.Context, f interface{}, params ...interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
-	return nil
+	return this.put(ctx, PRIORITY_NORMAL, f, nil, true, nil, params)
 }
 
 func (this *Post) PutQueueSpecStrict(f interface{}, params ...interface{}) error {
@@ -181,17 +324,41 @@ func (this *Post) PutQueueSpecStrict(f interface{}, params ...interface{}) error
 }
 
 // Append an asynchronous task, new worker will be created dynamically by the group.
-func (this *Post) PutJob(group string, f interface{}, params ...interface{}) {
-	worker := getJobWorker(group)
-	worker.appendJob(f, false, params)
+func (this *Post) PutJob(group string, f interface{}, params ...interface{}) error {
+	return appendGroupJob(nil, group, newJobMsg(f, nil, params, nil, false))
+}
+
+// Append an asynchronous task which will be skipped if ctx is done before execution,
+// it returns an error if ctx is done before the job is queued.
+func (this *Post) PutJobCtx(ctx context.Context, group string, f interface{}, params ...interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return appendGroupJob(ctx, group, newJobMsg(f, nil, params, nil, false))
+}
+
+func (this *Post) PutJobWithCallback(group string, f, cb interface{}, cbParams []interface{}, params ...interface{}) error {
+	return appendGroupJob(nil, group, newJobMsg(f, cb, params, cbParams, false))
+}
+
+func (this *Post) PutJobWithCallbackCtx(ctx context.Context, group string, f, cb interface{}, cbParams []interface{}, params ...interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return appendGroupJob(ctx, group, newJobMsg(f, cb, params, cbParams, false))
 }
 
-func (this *Post) PutJobWithCallback(group string, f, cb interface{}, cbParams []interface{}, params ...interface{}) {
-	worker := getJobWorker(group)
-	worker.appendJobWithCallback(f, cb, cbParams, params)
+func (this *Post) putJobFunc(group string, fn func()) error {
+	return appendGroupJob(nil, group, newJobMsg(fn, nil, nil, nil, false))
 }
 
-func (this *Post) PutJobStrict(group string, f interface{}, params ...interface{}) {
-	worker := getJobWorker(group)
-	worker.appendJob(f, true, params)
+func (this *Post) PutJobStrict(group string, f interface{}, params ...interface{}) error {
+	return appendGroupJob(nil, group, newJobMsg(f, nil, params, nil, true))
+}
+
+func (this *Post) PutJobStrictCtx(ctx context.Context, group string, f interface{}, params ...interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return appendGroupJob(ctx, group, newJobMsg(f, nil, params, nil, true))
 }
@@ -1,7 +1,9 @@
 package post
 
 import (
+	"context"
 	"fmt"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -54,3 +56,75 @@ func TestPost(t *testing.T) {
 	assert.Equal(t, 1, a)
 	p.Close()
 }
+
+func TestPostShutdown(t *testing.T) {
+	a := 0
+	var busy int32
+	p := NewPost(uint64(1024), 1)
+	block := make(chan bool)
+	p.PutQueue(func() {
+		atomic.StoreInt32(&busy, 1)
+		<-block
+		time.Sleep(5 * time.Millisecond)
+		atomic.StoreInt32(&busy, 0)
+	})
+	time.Sleep(15 * time.Millisecond)
+	for i := 0; i < 10; i++ {
+		// the remaining jobs are executed after the job of the loop.
+		p.PutQueue(func(d *int) {
+			if atomic.LoadInt32(&busy) == 0 {
+				*d++
+			}
+		}, &a)
+	}
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		close(block)
+	}()
+	dropped, err := p.Shutdown(context.Background())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, dropped)
+	assert.Equal(t, 10, a)
+	assert.Equal(t, ErrClosed, p.PutQueueSpec(func() {}))
+
+	// the remaining jobs are executed by the caller until deadline.
+	b := 0
+	p = NewPost(uint64(1024), 1)
+	block2 := make(chan bool)
+	p.PutQueue(func() { <-block2 })
+	time.Sleep(15 * time.Millisecond)
+	go func() {
+		time.Sleep(time.Millisecond)
+		close(block2)
+	}()
+	for i := 0; i < 10; i++ {
+		p.PutQueue(func(d *int) {
+			time.Sleep(2 * time.Millisecond)
+			*d++
+		}, &b)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	dropped, err = p.Shutdown(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, true, dropped > 0)
+	assert.Equal(t, 10, dropped+b)
+
+	// the objects are drained concurrently, the blocked one doesn't use up the deadline of the others.
+	c := int32(0)
+	p = NewPost(uint64(1024), 2)
+	block3 := make(chan bool)
+	defer close(block3)
+	p.objects[0].PutQueue(func() { <-block3 }, false)
+	time.Sleep(15 * time.Millisecond)
+	for i := 0; i < 10; i++ {
+		p.objects[0].PutQueue(func() {}, false)
+		p.objects[1].PutQueue(func() { atomic.AddInt32(&c, 1) }, false)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	dropped, err = p.Shutdown(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, 10, dropped)
+	assert.Equal(t, int32(10), atomic.LoadInt32(&c))
+}
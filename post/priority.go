@@ -0,0 +1,148 @@
+// Priority lanes of objects, each lane is a lock-free queue.
+package post
+
+import (
+	"context"
+
+	"github.com/TianQinS/fastapi/basic"
+)
+
+const (
+	// the zero value is the normal priority which uses RpcObject.Queue.
+	PRIORITY_NORMAL Priority = iota
+	PRIORITY_HIGH
+	PRIORITY_LOW
+)
+
+const (
+	// jobs in lower lanes are executed only when higher lanes are empty, the default policy.
+	DRAIN_STRICT DrainPolicy = iota
+	// each lane is drained with a limited number of jobs per round.
+	DRAIN_WEIGHTED
+)
+
+const (
+	PRIORITY_NUM = 3
+	// the capacity of high and low lanes is a fraction of the normal one.
+	LANE_CAPACITY_RATIO = 8
+	MIN_LANE_CAPACITY   = 16
+)
+
+var (
+	// lanes are drained from high to low.
+	laneOrder = [PRIORITY_NUM]Priority{PRIORITY_HIGH, PRIORITY_NORMAL, PRIORITY_LOW}
+)
+
+type Priority int32
+
+type DrainPolicy int32
+
+func (this *RpcObject) initLanes(qSize uint64) {
+	size := qSize / LANE_CAPACITY_RATIO
+	if size < MIN_LANE_CAPACITY {
+		size = MIN_LANE_CAPACITY
+	}
+	this.lanes[PRIORITY_NORMAL] = this.Queue
+	this.lanes[PRIORITY_HIGH] = basic.NewQueue(size)
+	this.lanes[PRIORITY_LOW] = basic.NewQueue(size)
+	this.laneVals = make([]interface{}, size)
+}
+
+// Set the drain policy before the loop is started, weights are the maximum jobs per round
+// indexed by priority in DRAIN_WEIGHTED, and zero means unlimited.
+func (this *RpcObject) SetDrainPolicy(policy DrainPolicy, weights [PRIORITY_NUM]int) {
+	this.drain = policy
+	this.weights = weights
+}
+
+func (this *RpcObject) lane(priority Priority) *basic.EsQueue {
+	if priority < 0 || priority >= PRIORITY_NUM {
+		return this.Queue
+	}
+	return this.lanes[priority]
+}
+
+// The number of jobs in all lanes.
+func (this *RpcObject) quantity() (quantity uint64) {
+	for _, q := range this.lanes {
+		quantity += q.Quantity()
+	}
+	return
+}
+
+func (this *RpcObject) capacity() (capacity uint64) {
+	for _, q := range this.lanes {
+		capacity += q.Capacity()
+	}
+	return
+}
+
+// Get a job from the lanes by priority.
+func (this *RpcObject) getOne() (*QueueMsg, bool) {
+	for _, lane := range laneOrder {
+		if val, ok, _ := this.lanes[lane].Get(); ok {
+			return val.(*QueueMsg), true
+		}
+	}
+	return nil, false
+}
+
+// Execute the jobs of higher lanes first in DRAIN_STRICT.
+func (this *RpcObject) preempt(priority Priority) {
+	for _, lane := range laneOrder {
+		if lane == priority {
+			return
+		}
+		for q := this.lanes[lane]; q.Quantity() > 0; {
+			cnt, _ := q.Gets(this.laneVals)
+			// no more preemption, the buffer is in use.
+			this.executeEvent(cnt, &this.laneVals, PRIORITY_HIGH)
+		}
+	}
+}
+
+// Execute a round of all lanes, it returns the number of jobs executed.
+func (this *RpcObject) executeLanes(vals []interface{}) (total uint64) {
+	for _, lane := range laneOrder {
+		buf := vals
+		if w := this.weights[lane]; this.drain == DRAIN_WEIGHTED && w > 0 && w < len(buf) {
+			buf = buf[:w]
+		}
+		cnt, _ := this.lanes[lane].Gets(buf)
+		this.executeEvent(cnt, &buf, lane)
+		total += cnt
+	}
+	// jobs in the overflow slice are newer than those in the queue.
+	for _, msg := range this.takeSpill() {
+		this.executeMsg(msg)
+		total++
+	}
+	return
+}
+
+func (this *RpcObject) PutQueuePriority(priority Priority, f interface{}, strictUnReflect bool, params ...interface{}) error {
+	msg := this.newMsg(f, nil, params, nil, strictUnReflect)
+	msg.Priority = priority
+	return this.putMsg(msg)
+}
+
+// Call a function with the priority, jobs of higher priority are executed first.
+func (this *Post) PutQueuePriority(priority Priority, f interface{}, params ...interface{}) error {
+	return this.put(nil, priority, f, nil, false, nil, params)
+}
+
+func (this *Post) PutQueuePriorityCtx(ctx context.Context, priority Priority, f interface{}, params ...interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return this.put(ctx, priority, f, nil, false, nil, params)
+}
+
+func (this *Post) PutQueueStrictPriority(priority Priority, f interface{}, params ...interface{}) error {
+	return this.put(nil, priority, f, nil, true, nil, params)
+}
+
+// Call a function in the special routine with the priority.
+func (this *Post) PutQueueSpecPriority(priority Priority, f interface{}, params ...interface{}) error {
+	return this.Object.PutQueuePriority(priority, f, false, params...)
+}
@@ -0,0 +1,77 @@
+package post
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func putPriority(obj *RpcObject, seq *[]Priority, priority Priority, num int) {
+	for i := 0; i < num; i++ {
+		obj.PutQueuePriority(priority, func() {
+			*seq = append(*seq, priority)
+		}, false)
+	}
+}
+
+func TestPriorityStrict(t *testing.T) {
+	seq := []Priority{}
+	obj := newOverflowObject(OVERFLOW_FAIL, 0)
+	putPriority(obj, &seq, PRIORITY_LOW, 2)
+	putPriority(obj, &seq, PRIORITY_NORMAL, 2)
+	putPriority(obj, &seq, PRIORITY_HIGH, 2)
+	assert.Equal(t, uint64(6), obj.depth())
+	assert.Equal(t, uint64(6), obj.ExecuteEvent())
+	assert.Equal(t, []Priority{PRIORITY_HIGH, PRIORITY_HIGH, PRIORITY_NORMAL, PRIORITY_NORMAL, PRIORITY_LOW, PRIORITY_LOW}, seq)
+
+	// high jobs put by a running job preempt the remaining lower ones.
+	seq = seq[:0]
+	obj.PutQueuePriority(PRIORITY_LOW, func() {
+		seq = append(seq, PRIORITY_LOW)
+		putPriority(obj, &seq, PRIORITY_HIGH, 1)
+	}, false)
+	putPriority(obj, &seq, PRIORITY_LOW, 1)
+	obj.ExecuteEvent()
+	assert.Equal(t, []Priority{PRIORITY_LOW, PRIORITY_HIGH, PRIORITY_LOW}, seq)
+}
+
+func TestPriorityWeighted(t *testing.T) {
+	seq := []Priority{}
+	obj := newOverflowObject(OVERFLOW_FAIL, 0)
+	obj.SetDrainPolicy(DRAIN_WEIGHTED, [PRIORITY_NUM]int{PRIORITY_NORMAL: 2, PRIORITY_HIGH: 3, PRIORITY_LOW: 1})
+	putPriority(obj, &seq, PRIORITY_LOW, 4)
+	putPriority(obj, &seq, PRIORITY_NORMAL, 4)
+	putPriority(obj, &seq, PRIORITY_HIGH, 4)
+	assert.Equal(t, uint64(6), obj.ExecuteEvent())
+	assert.Equal(t, []Priority{PRIORITY_HIGH, PRIORITY_HIGH, PRIORITY_HIGH, PRIORITY_NORMAL, PRIORITY_NORMAL, PRIORITY_LOW}, seq)
+	assert.Equal(t, uint64(4), obj.ExecuteEvent())
+	// the low lane gets one job per round.
+	assert.Equal(t, uint64(1), obj.ExecuteEvent())
+	assert.Equal(t, uint64(1), obj.ExecuteEvent())
+	assert.Equal(t, uint64(0), obj.depth())
+}
+
+func TestPostPriority(t *testing.T) {
+	var lock sync.Mutex
+	seq := []Priority{}
+	p := NewPost(uint64(64), 1, WithDrainPolicy(DRAIN_STRICT, [PRIORITY_NUM]int{}))
+	block := make(chan struct{})
+	p.PutQueue(func() { <-block })
+	for _, priority := range []Priority{PRIORITY_LOW, PRIORITY_NORMAL, PRIORITY_HIGH} {
+		pri := priority
+		assert.Nil(t, p.PutQueuePriority(pri, func() {
+			lock.Lock()
+			seq = append(seq, pri)
+			lock.Unlock()
+		}))
+	}
+	close(block)
+	time.Sleep(5 * MAX_SLEEP_TIME)
+	lock.Lock()
+	assert.Equal(t, []Priority{PRIORITY_HIGH, PRIORITY_NORMAL, PRIORITY_LOW}, seq)
+	lock.Unlock()
+	assert.Equal(t, uint64(64+2*MIN_LANE_CAPACITY), p.Stats().Objects[0].Capacity)
+	p.Close()
+}
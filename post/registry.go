@@ -0,0 +1,173 @@
+// Concurrency-safe registry of named functions, the readers never lock.
+package post
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	ErrFuncExists   = errors.New("post: function already registered")
+	ErrFuncNotFound = errors.New("post: function not found")
+)
+
+type FuncEntry struct {
+	ID   string
+	Func interface{}
+	// the version of the registry when the function is set.
+	Version   uint64
+	Signature *Signature
+}
+
+// The functions are kept in a copy-on-write map, it can be shared by a post and it's objects.
+type Registry struct {
+	lock    sync.Mutex
+	table   atomic.Pointer[map[string]*FuncEntry]
+	version uint64
+	// coerce the parameters of named calls.
+	coerce int32
+}
+
+func NewRegistry() *Registry {
+	this := &Registry{}
+	table := make(map[string]*FuncEntry)
+	this.table.Store(&table)
+	return this
+}
+
+// f can be any function type, but must be an `func(args ...interface{})` type in strict mode without reflect.
+func validateFunc(id string, f interface{}) error {
+	if typ := reflect.TypeOf(f); typ == nil || typ.Kind() != reflect.Func || reflect.ValueOf(f).IsNil() {
+		return fmt.Errorf("function id %v: invalid function %T", id, f)
+	}
+	return nil
+}
+
+// Check the new function has the same signature, so the jobs in queue can still be called.
+func validateReplace(id string, old, f interface{}) error {
+	if err := validateFunc(id, f); err != nil {
+		return err
+	}
+	if reflect.TypeOf(old) != reflect.TypeOf(f) {
+		return fmt.Errorf("function id %v: signature %T mismatches %T", id, f, old)
+	}
+	return nil
+}
+
+// Apply the changes to a copy of the table, it must be called with the lock.
+func (this *Registry) update(changes map[string]interface{}) uint64 {
+	old := *this.table.Load()
+	table := make(map[string]*FuncEntry, len(old)+len(changes))
+	for id, entry := range old {
+		table[id] = entry
+	}
+	this.version++
+	for id, f := range changes {
+		if f == nil {
+			delete(table, id)
+		} else {
+			table[id] = &FuncEntry{ID: id, Func: f, Version: this.version, Signature: newSignature(f)}
+		}
+	}
+	this.table.Store(&table)
+	return this.version
+}
+
+func (this *Registry) Register(id string, f interface{}) error {
+	if err := validateFunc(id, f); err != nil {
+		return err
+	}
+	defer this.lock.Unlock()
+	this.lock.Lock()
+	if _, ok := (*this.table.Load())[id]; ok {
+		return fmt.Errorf("function id %v: %w", id, ErrFuncExists)
+	}
+	this.update(map[string]interface{}{id: f})
+	return nil
+}
+
+// Register or overwrite the function without checking the signature, it returns the new version.
+// The jobs in queue are called with the new function, so use Replace for a safe hotfix.
+func (this *Registry) Set(id string, f interface{}) (uint64, error) {
+	if err := validateFunc(id, f); err != nil {
+		return this.Version(), err
+	}
+	defer this.lock.Unlock()
+	this.lock.Lock()
+	return this.update(map[string]interface{}{id: f}), nil
+}
+
+// Replace the function with the same signature, it returns the new version.
+func (this *Registry) Replace(id string, f interface{}) (uint64, error) {
+	return this.Swap(map[string]interface{}{id: f})
+}
+
+// Replace the functions atomically for a hotfix, none of them is replaced if any one is invalid.
+func (this *Registry) Swap(funcs map[string]interface{}) (uint64, error) {
+	defer this.lock.Unlock()
+	this.lock.Lock()
+	table := *this.table.Load()
+	for id, f := range funcs {
+		entry, ok := table[id]
+		if !ok {
+			return this.version, fmt.Errorf("function id %v: %w", id, ErrFuncNotFound)
+		}
+		if err := validateReplace(id, entry.Func, f); err != nil {
+			return this.version, err
+		}
+	}
+	return this.update(funcs), nil
+}
+
+func (this *Registry) Unregister(id string) bool {
+	defer this.lock.Unlock()
+	this.lock.Lock()
+	if _, ok := (*this.table.Load())[id]; !ok {
+		return false
+	}
+	this.update(map[string]interface{}{id: nil})
+	return true
+}
+
+func (this *Registry) entry(id string) (*FuncEntry, bool) {
+	entry, ok := (*this.table.Load())[id]
+	return entry, ok
+}
+
+func (this *Registry) Get(id string) (interface{}, bool) {
+	if entry, ok := this.entry(id); ok {
+		return entry.Func, true
+	}
+	return nil, false
+}
+
+func (this *Registry) Lookup(id string) (FuncEntry, bool) {
+	if entry, ok := this.entry(id); ok {
+		return *entry, true
+	}
+	return FuncEntry{}, false
+}
+
+// List the functions by id.
+func (this *Registry) List() []FuncEntry {
+	table := *this.table.Load()
+	entries := make([]FuncEntry, 0, len(table))
+	for _, entry := range table {
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID < entries[j].ID
+	})
+	return entries
+}
+
+// The version is increased by each change.
+func (this *Registry) Version() uint64 {
+	defer this.lock.Unlock()
+	this.lock.Lock()
+	return this.version
+}
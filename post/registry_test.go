@@ -0,0 +1,92 @@
+package post
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	assert.Nil(t, r.Register("add", func(a, b int) int { return a + b }))
+	assert.True(t, errors.Is(r.Register("add", func() {}), ErrFuncExists))
+	assert.NotNil(t, r.Register("bad", 1))
+	assert.NotNil(t, r.Register("nil", (func())(nil)))
+	assert.Equal(t, uint64(1), r.Version())
+
+	version, err := r.Replace("add", func(a, b int) int { return a - b })
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), version)
+	entry, ok := r.Lookup("add")
+	assert.True(t, ok)
+	assert.Equal(t, uint64(2), entry.Version)
+	assert.Equal(t, 1, entry.Func.(func(a, b int) int)(3, 2))
+
+	// the signature must be the same.
+	_, err = r.Replace("add", func(a int) int { return a })
+	assert.NotNil(t, err)
+	_, err = r.Replace("missing", func() {})
+	assert.True(t, errors.Is(err, ErrFuncNotFound))
+
+	assert.Nil(t, r.Register("sub", func(a, b int) int { return a - b }))
+	// none of them is replaced if any one is invalid.
+	_, err = r.Swap(map[string]interface{}{
+		"add": func(a, b int) int { return a * b },
+		"sub": func() {},
+	})
+	assert.NotNil(t, err)
+	f, _ := r.Get("add")
+	assert.Equal(t, 1, f.(func(a, b int) int)(3, 2))
+
+	list := r.List()
+	assert.Equal(t, 2, len(list))
+	assert.Equal(t, "add", list[0].ID)
+	version, err = r.Set("sub", func() {})
+	assert.Nil(t, err)
+	assert.Equal(t, r.Version(), version)
+	_, err = r.Set("sub", 1)
+	assert.NotNil(t, err)
+	assert.True(t, r.Unregister("sub"))
+	assert.False(t, r.Unregister("sub"))
+	_, ok = r.Get("sub")
+	assert.False(t, ok)
+}
+
+func TestRegistryHotfix(t *testing.T) {
+	p := NewPost(uint64(1024), 2)
+	var v1, v2 int32
+	p.Register("reward", func() { atomic.AddInt32(&v1, 1) })
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			p.PutQueue("reward")
+		}
+	}()
+	_, err := p.Functions.Replace("reward", func() { atomic.AddInt32(&v2, 1) })
+	assert.Nil(t, err)
+	wg.Wait()
+	// the job put after the hotfix calls the new function.
+	assert.Nil(t, p.PutQueue("reward"))
+	_, err = p.Shutdown(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, int32(201), atomic.LoadInt32(&v1)+atomic.LoadInt32(&v2))
+	assert.True(t, atomic.LoadInt32(&v2) > 0)
+}
+
+func TestObjectRegister(t *testing.T) {
+	obj := newOverflowObject(OVERFLOW_FAIL, 0)
+	obj.Register("reward", func() {})
+	// the object overwrites the function like a map, even if the signature is changed.
+	obj.Register("reward", func(i int) {})
+	f, ok := obj.Functions.Get("reward")
+	assert.True(t, ok)
+	_, ok = f.(func(i int))
+	assert.True(t, ok)
+	assert.Equal(t, uint64(2), obj.Functions.Version())
+}
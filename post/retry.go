@@ -0,0 +1,147 @@
+// Retry policies for panicked jobs, the retries are rescheduled without blocking the workers.
+package post
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DEFAULT_RETRY_MULTIPLIER = 2.0
+)
+
+var (
+	// the scheduler for delayed retries, it's replaced by the timer package.
+	retryScheduler = func(d time.Duration, f func()) {
+		time.AfterFunc(d, f)
+	}
+)
+
+type RetryPolicy struct {
+	// the maximum attempts including the first one, no retry if it's less than 2.
+	MaxAttempts int
+	// the delay before the first retry.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// the growth factor of the delay, 2 by default.
+	Multiplier float64
+	// the delay is randomized within [1-Jitter, 1+Jitter] times.
+	Jitter float64
+	// only retry for the errors accepted, all errors are retried if it's nil.
+	RetryOn func(err error) bool
+}
+
+// Set the scheduler for delayed retries, f should be executed after d asynchronously.
+func SetRetryScheduler(scheduler func(d time.Duration, f func())) {
+	retryScheduler = scheduler
+}
+
+// The delay before the attempt, attempt starts from 1 for the first retry.
+func (this *RetryPolicy) delay(attempt int) time.Duration {
+	multiplier := this.Multiplier
+	if multiplier <= 0 {
+		multiplier = DEFAULT_RETRY_MULTIPLIER
+	}
+	d := float64(this.Backoff)
+	for i := 1; i < attempt; i++ {
+		d *= multiplier
+		if this.MaxBackoff > 0 && d >= float64(this.MaxBackoff) {
+			break
+		}
+	}
+	if this.MaxBackoff > 0 && d > float64(this.MaxBackoff) {
+		d = float64(this.MaxBackoff)
+	}
+	if this.Jitter > 0 {
+		d *= 1 + this.Jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(d)
+}
+
+// Reschedule a copy of the failed job if the policy allows, the future isn't resolved until the last attempt.
+func (this *QueueMsg) retry(err error, stats *counters) bool {
+	policy := this.Retry
+	if policy == nil || this.requeue == nil || this.Attempt+1 >= policy.MaxAttempts || this.cancelled() {
+		return false
+	}
+	if policy.RetryOn != nil && !policy.RetryOn(err) {
+		return false
+	}
+	atomic.AddUint64(&stats.retried, 1)
+	msg := this.clone()
+	msg.Attempt++
+	retryScheduler(policy.delay(msg.Attempt), func() {
+		if e := msg.requeue(&msg); e != nil {
+			msg.resolve(nil, e)
+		}
+	})
+	return true
+}
+
+// Set the retry policy of the registered function, the policy is removed if it's nil.
+func (this *Post) SetRetryPolicy(id string, policy *RetryPolicy) {
+	if policy == nil {
+		this.retries.Delete(id)
+		return
+	}
+	this.retries.Store(id, policy)
+}
+
+// The policy of the registered function is used if no policy is specified for the job.
+func (this *Post) retryPolicy(msg *QueueMsg) {
+	if msg.Retry != nil {
+		return
+	}
+	if id, ok := msg.Func.(string); ok {
+		if policy, ok := this.retries.Load(id); ok {
+			msg.Retry = policy.(*RetryPolicy)
+		}
+	}
+}
+
+// Put the failed job to a running object again.
+func (this *Post) requeue(msg *QueueMsg) error {
+	o := this.pick(msg.Func)
+	if o == nil {
+		return this.noWorkersError()
+	}
+	return this.putMsg(o, msg)
+}
+
+// Call a function which is retried with the policy if it panics.
+func (this *Post) PutQueueRetry(policy *RetryPolicy, f interface{}, params ...interface{}) error {
+	o := this.pick(f)
+	if o == nil {
+		return this.noWorkersError()
+	}
+	msg := o.newMsg(f, nil, params, nil, false)
+	msg.Retry = policy
+	return this.putMsg(o, msg)
+}
+
+// The future is resolved after the job succeeds or the last attempt fails.
+func (this *Post) SubmitRetry(policy *RetryPolicy, f interface{}, params ...interface{}) *Future {
+	o := this.pick(f)
+	if o == nil {
+		return rejectedFuture(this.noWorkersError())
+	}
+	future := NewFuture()
+	msg := o.newMsg(f, nil, params, nil, false)
+	msg.Retry = policy
+	msg.Future = future
+	if err := this.putMsg(o, msg); err != nil {
+		future.resolve(nil, err)
+	}
+	return future
+}
+
+// Append an asynchronous task which is retried in the same group if it panics.
+func (this *Post) PutJobRetry(group string, policy *RetryPolicy, f interface{}, params ...interface{}) error {
+	msg := newJobMsg(f, nil, params, nil, false)
+	msg.Retry = policy
+	msg.requeue = func(msg *QueueMsg) error {
+		return appendGroupJob(nil, group, msg)
+	}
+	return appendGroupJob(nil, group, msg)
+}
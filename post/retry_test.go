@@ -0,0 +1,89 @@
+package post
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errTransient = errors.New("transient")
+
+// A function which panics before the n-th call.
+func flaky(n int32, calls *int32) func() int32 {
+	return func() int32 {
+		if c := atomic.AddInt32(calls, 1); c < n {
+			panic(errTransient)
+		}
+		return atomic.LoadInt32(calls)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	policy := &RetryPolicy{Backoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+	assert.Equal(t, time.Millisecond, policy.delay(1))
+	assert.Equal(t, 4*time.Millisecond, policy.delay(3))
+	assert.Equal(t, 5*time.Millisecond, policy.delay(10))
+
+	policy.Jitter = 0.5
+	for i := 0; i < 10; i++ {
+		d := policy.delay(2)
+		assert.True(t, d >= time.Millisecond && d <= 3*time.Millisecond)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	p := NewPost(uint64(64), 2)
+	policy := &RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}
+
+	var calls int32
+	rets, err := p.SubmitRetry(policy, flaky(3, &calls)).Wait(time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{int32(3)}, rets)
+
+	// give up after the max attempts.
+	atomic.StoreInt32(&calls, 0)
+	_, err = p.SubmitRetry(policy, flaky(5, &calls)).Wait(time.Second)
+	assert.Equal(t, errTransient, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	// the error isn't accepted by the predicate.
+	atomic.StoreInt32(&calls, 0)
+	policy = &RetryPolicy{MaxAttempts: 3, RetryOn: func(err error) bool {
+		return err != errTransient
+	}}
+	_, err = p.SubmitRetry(policy, flaky(2, &calls)).Wait(time.Second)
+	assert.Equal(t, errTransient, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	var retried uint64
+	for _, s := range p.Stats().Objects {
+		retried += s.Retried
+	}
+	assert.Equal(t, uint64(4), retried)
+	p.Close()
+}
+
+func TestRetryPolicy(t *testing.T) {
+	p := NewPost(uint64(64), 2)
+	var calls int32
+	p.Register("reward", flaky(2, &calls))
+	p.SetRetryPolicy("reward", &RetryPolicy{MaxAttempts: 2})
+	assert.Nil(t, p.PutQueue("reward"))
+	time.Sleep(5 * MAX_SLEEP_TIME)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	atomic.StoreInt32(&calls, 0)
+	p.SetRetryPolicy("reward", nil)
+	assert.Nil(t, p.PutQueue("reward"))
+	time.Sleep(5 * MAX_SLEEP_TIME)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	atomic.StoreInt32(&calls, 0)
+	assert.Nil(t, p.PutJobRetry("retry", &RetryPolicy{MaxAttempts: 3}, flaky(3, &calls)))
+	time.Sleep(5 * MAX_SLEEP_TIME)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+	p.Close()
+}
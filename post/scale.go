@@ -0,0 +1,150 @@
+// Adaptive autoscaling of the objects of Post by queue depth and execution latency.
+package post
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DEFAULT_SCALE_INTERVAL   = time.Second
+	DEFAULT_SCALE_HYSTERESIS = 3
+)
+
+type ScaleConfig struct {
+	// the bounds of the number of running objects.
+	Min int
+	Max int
+	// the interval between two checks.
+	Interval time.Duration
+	// scale up if the average queue depth of objects is above HighDepth,
+	// or the mean execution latency in the interval is above HighLatency.
+	HighDepth   uint64
+	HighLatency time.Duration
+	// scale down if the average queue depth is not above LowDepth and the latency is normal.
+	LowDepth uint64
+	// the number of consecutive checks that trigger scaling.
+	Hysteresis int
+}
+
+type scaler struct {
+	config ScaleConfig
+	stop   chan struct{}
+	// the latency counters of the last check.
+	count uint64
+	sum   time.Duration
+	// the consecutive checks of overload and underload.
+	ups   int
+	downs int
+}
+
+// Start the autoscaler, the zero fields of the config are set to default values.
+func (this *Post) StartAutoScale(config ScaleConfig) {
+	if config.Min <= 0 {
+		config.Min = 1
+	}
+	if config.Max < config.Min {
+		config.Max = runtime.NumCPU()
+		if config.Max < config.Min {
+			config.Max = config.Min
+		}
+	}
+	if config.Interval <= 0 {
+		config.Interval = DEFAULT_SCALE_INTERVAL
+	}
+	if config.HighDepth == 0 {
+		config.HighDepth = this.qSize / 8
+	}
+	if config.Hysteresis <= 0 {
+		config.Hysteresis = DEFAULT_SCALE_HYSTERESIS
+	}
+
+	s := &scaler{
+		config: config,
+		stop:   make(chan struct{}),
+	}
+	this.lock.Lock()
+	this.stopScale()
+	this.scaler = s
+	this.lock.Unlock()
+	go s.loop(this)
+}
+
+func (this *Post) StopAutoScale() {
+	defer this.lock.Unlock()
+	this.lock.Lock()
+	this.stopScale()
+}
+
+// Must be called with the lock.
+func (this *Post) stopScale() {
+	if this.scaler != nil {
+		close(this.scaler.stop)
+		this.scaler = nil
+	}
+}
+
+func (this *scaler) loop(p *Post) {
+	ticker := time.NewTicker(this.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-this.stop:
+			return
+		case <-ticker.C:
+			this.check(p)
+		}
+	}
+}
+
+// Check the load and add or retire one object at most.
+func (this *scaler) check(p *Post) {
+	// the scaler may tick once more after the post is closed.
+	if atomic.LoadInt32(&p.closed) == 1 {
+		return
+	}
+	stats := p.Stats()
+	size := len(stats.Objects)
+	if size < this.config.Min {
+		p.AddOne()
+		return
+	}
+
+	var depth, count uint64
+	var sum time.Duration
+	for _, s := range stats.Objects {
+		depth += s.Depth
+		count += s.Latency.Count
+		sum += s.Latency.Sum
+	}
+	depth /= uint64(size)
+	var latency time.Duration
+	if count > this.count {
+		latency = (sum - this.sum) / time.Duration(count-this.count)
+	}
+	this.count, this.sum = count, sum
+
+	overload := depth > this.config.HighDepth ||
+		(this.config.HighLatency > 0 && latency > this.config.HighLatency)
+	underload := depth <= this.config.LowDepth &&
+		(this.config.HighLatency <= 0 || latency <= this.config.HighLatency)
+	switch {
+	case overload:
+		this.ups++
+		this.downs = 0
+	case underload:
+		this.downs++
+		this.ups = 0
+	default:
+		this.ups, this.downs = 0, 0
+	}
+
+	if this.ups >= this.config.Hysteresis && size < this.config.Max {
+		this.ups = 0
+		p.AddOne()
+	} else if this.downs >= this.config.Hysteresis && size > this.config.Min {
+		this.downs = 0
+		p.DelOne()
+	}
+}
@@ -0,0 +1,55 @@
+package post
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAutoScale(t *testing.T) {
+	p := NewPost(uint64(1024), 1, WithAutoScale(ScaleConfig{
+		Min:        1,
+		Max:        3,
+		Interval:   5 * time.Millisecond,
+		HighDepth:  10,
+		Hysteresis: 2,
+	}))
+	block := make(chan bool)
+	for i := 0; i < 100; i++ {
+		p.PutQueue(func() { <-block })
+	}
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 3, len(p.Stats().Objects))
+
+	close(block)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 1, len(p.Stats().Objects))
+	p.Close()
+}
+
+func TestDelOneMigrate(t *testing.T) {
+	var a int32
+	p := NewPost(uint64(1024), 2)
+	block := make(chan bool)
+	// the first object is blocked, and jobs are queued in the second one.
+	p.PutQueue(func() { <-block })
+	p.PutQueue(func() { <-block })
+	time.Sleep(15 * time.Millisecond)
+	for i := 0; i < 10; i++ {
+		p.objects[1].PutQueue(func(d *int32) { atomic.AddInt32(d, 1) }, false, &a)
+	}
+	p.DelOne()
+	assert.Equal(t, uint64(10), p.objects[0].Stats().Depth)
+	close(block)
+	time.Sleep(15 * time.Millisecond)
+	assert.Equal(t, int32(10), atomic.LoadInt32(&a))
+
+	p.AddOne()
+	assert.Equal(t, 2, p.Size())
+	p.Close()
+	// no object is added after closed.
+	assert.Nil(t, p.AddOne())
+	assert.Equal(t, 0, len(p.runningObjects()))
+}
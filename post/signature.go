@@ -0,0 +1,261 @@
+// Signatures of named functions, the parameters are validated and optionally coerced before call.
+package post
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+var (
+	ErrBadParams = errors.New("post: bad parameters")
+)
+
+type Signature struct {
+	// the types of parameters without the leading context.Context.
+	In       []reflect.Type
+	Variadic bool
+	Context  bool
+}
+
+func newSignature(f interface{}) *Signature {
+	typ := reflect.TypeOf(f)
+	this := &Signature{
+		Variadic: typ.IsVariadic(),
+		Context:  acceptContext(typ),
+	}
+	for i := 0; i < typ.NumIn(); i++ {
+		if i == 0 && this.Context {
+			continue
+		}
+		this.In = append(this.In, typ.In(i))
+	}
+	return this
+}
+
+func (this *Signature) String() string {
+	in := make([]string, len(this.In))
+	for i, typ := range this.In {
+		in[i] = typ.String()
+		if this.Variadic && i == len(this.In)-1 {
+			in[i] = "..." + typ.Elem().String()
+		}
+	}
+	return "(" + strings.Join(in, ", ") + ")"
+}
+
+// The type of the i-th parameter.
+func (this *Signature) param(i int) reflect.Type {
+	if this.Variadic && i >= len(this.In)-1 {
+		return this.In[len(this.In)-1].Elem()
+	}
+	return this.In[i]
+}
+
+// Check the parameters, a new slice is returned if any of them is coerced.
+func (this *Signature) check(id string, params []interface{}, coerce bool) ([]interface{}, error) {
+	n := len(this.In)
+	if (!this.Variadic && len(params) != n) || (this.Variadic && len(params) < n-1) {
+		return nil, fmt.Errorf("%w: %s%s called with %d parameters", ErrBadParams, id, this, len(params))
+	}
+	checked := params
+	for i, param := range params {
+		typ := this.param(i)
+		if param == nil {
+			if !nillable(typ.Kind()) {
+				return nil, fmt.Errorf("%w: %s%s parameter %d is nil for %v", ErrBadParams, id, this, i, typ)
+			}
+			continue
+		}
+		if reflect.TypeOf(param).AssignableTo(typ) {
+			continue
+		}
+		val, ok := param, false
+		if coerce {
+			val, ok = coerceParam(param, typ)
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: %s%s parameter %d is %T for %v", ErrBadParams, id, this, i, param, typ)
+		}
+		if &checked[0] == &params[0] {
+			checked = make([]interface{}, len(params))
+			copy(checked, params)
+		}
+		checked[i] = val
+	}
+	return checked, nil
+}
+
+func nillable(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Interface, reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		return true
+	}
+	return false
+}
+
+func isInt(kind reflect.Kind) bool {
+	return kind >= reflect.Int && kind <= reflect.Int64
+}
+
+func isUint(kind reflect.Kind) bool {
+	return kind >= reflect.Uint && kind <= reflect.Uintptr
+}
+
+func isFloat(kind reflect.Kind) bool {
+	return kind == reflect.Float32 || kind == reflect.Float64
+}
+
+// Convert the common types from scripts or RPC, the numbers must fit the type without loss.
+func coerceParam(param interface{}, typ reflect.Type) (interface{}, bool) {
+	val := reflect.ValueOf(param)
+	kind := val.Kind()
+	out := reflect.New(typ).Elem()
+	switch {
+	case param == nil:
+		return nil, false
+	case isInt(kind):
+		if typ.Kind() == reflect.String {
+			return reflect.ValueOf(strconv.FormatInt(val.Int(), 10)).Convert(typ).Interface(), true
+		}
+		return setInt(out, val.Int())
+	case isUint(kind):
+		return setUint(out, val.Uint())
+	case isFloat(kind):
+		return setFloat(out, val.Float())
+	case kind == reflect.String:
+		s := val.String()
+		if n, ok := param.(json.Number); ok {
+			s = n.String()
+		}
+		// parse the integers exactly.
+		switch k := typ.Kind(); {
+		case k == reflect.String:
+			return reflect.ValueOf(s).Convert(typ).Interface(), true
+		case isInt(k):
+			if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+				return setInt(out, i)
+			}
+		case isUint(k):
+			if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+				return setUint(out, u)
+			}
+		default:
+			if f, err := strconv.ParseFloat(s, 64); err == nil {
+				return setFloat(out, f)
+			}
+		}
+	}
+	return nil, false
+}
+
+// Set the integer to the number value, it fails if the integer doesn't fit the type.
+func setInt(out reflect.Value, i int64) (interface{}, bool) {
+	switch k := out.Kind(); {
+	case isInt(k):
+		if out.OverflowInt(i) {
+			return nil, false
+		}
+		out.SetInt(i)
+	case isUint(k):
+		if i < 0 || out.OverflowUint(uint64(i)) {
+			return nil, false
+		}
+		out.SetUint(uint64(i))
+	case isFloat(k):
+		// the integer above 2^53 may not be represented exactly.
+		f := float64(i)
+		if f >= 1<<63 || int64(f) != i {
+			return nil, false
+		}
+		return setFloat(out, f)
+	default:
+		return nil, false
+	}
+	return out.Interface(), true
+}
+
+// Set the unsigned integer to the number value, it fails if the integer doesn't fit the type.
+func setUint(out reflect.Value, u uint64) (interface{}, bool) {
+	switch k := out.Kind(); {
+	case isInt(k):
+		if u > math.MaxInt64 || out.OverflowInt(int64(u)) {
+			return nil, false
+		}
+		out.SetInt(int64(u))
+	case isUint(k):
+		if out.OverflowUint(u) {
+			return nil, false
+		}
+		out.SetUint(u)
+	case isFloat(k):
+		f := float64(u)
+		if f >= 1<<64 || uint64(f) != u {
+			return nil, false
+		}
+		return setFloat(out, f)
+	default:
+		return nil, false
+	}
+	return out.Interface(), true
+}
+
+// Set the float to the number value, it must be an integer in range for the integer types.
+func setFloat(out reflect.Value, f float64) (interface{}, bool) {
+	switch k := out.Kind(); {
+	case isInt(k):
+		if f != math.Trunc(f) || f < -(1<<63) || f >= 1<<63 || out.OverflowInt(int64(f)) {
+			return nil, false
+		}
+		out.SetInt(int64(f))
+	case isUint(k):
+		if f != math.Trunc(f) || f < 0 || f >= 1<<64 || out.OverflowUint(uint64(f)) {
+			return nil, false
+		}
+		out.SetUint(uint64(f))
+	case isFloat(k):
+		if out.OverflowFloat(f) {
+			return nil, false
+		}
+		out.SetFloat(f)
+	default:
+		return nil, false
+	}
+	return out.Interface(), true
+}
+
+// Enable the coercion of parameters for named calls.
+func (this *Registry) SetCoercion(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&this.coerce, v)
+}
+
+func (this *Registry) coercion() bool {
+	return atomic.LoadInt32(&this.coerce) == 1
+}
+
+// Check the parameters of the message if it's function is registered, they may be coerced.
+func (this *Registry) checkMsg(msg *QueueMsg) error {
+	id, ok := msg.Func.(string)
+	if !ok {
+		return nil
+	}
+	entry, ok := this.entry(id)
+	if !ok {
+		return nil
+	}
+	params, err := entry.Signature.check(id, msg.Params, this.coercion())
+	if err != nil {
+		return err
+	}
+	msg.Params = params
+	return nil
+}
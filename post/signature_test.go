@@ -0,0 +1,106 @@
+package post
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type gold int
+
+func TestSignature(t *testing.T) {
+	sig := newSignature(func(ctx context.Context, a int, b ...string) {})
+	assert.True(t, sig.Context)
+	assert.True(t, sig.Variadic)
+	assert.Equal(t, "(int, ...string)", sig.String())
+
+	params := []interface{}{1, "a", "b"}
+	checked, err := sig.check("f", params, false)
+	assert.Nil(t, err)
+	assert.Equal(t, params, checked)
+	_, err = sig.check("f", nil, false)
+	assert.True(t, errors.Is(err, ErrBadParams))
+	_, err = sig.check("f", []interface{}{1, 2}, false)
+	assert.Equal(t, "post: bad parameters: f(int, ...string) parameter 1 is int for string", err.Error())
+	_, err = sig.check("f", []interface{}{nil}, false)
+	assert.NotNil(t, err)
+
+	// the parameters are coerced to a new slice.
+	params = []interface{}{json.Number("7"), 5}
+	checked, err = sig.check("f", params, true)
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{7, "5"}, checked)
+	assert.Equal(t, json.Number("7"), params[0])
+
+	sig = newSignature(func(p *int, m map[string]int) {})
+	_, err = sig.check("g", []interface{}{nil, nil}, false)
+	assert.Nil(t, err)
+}
+
+func TestCoerceParam(t *testing.T) {
+	cases := []struct {
+		param interface{}
+		typ   interface{}
+		want  interface{}
+	}{
+		{float64(3), int(0), 3},
+		{3.5, int(0), nil},
+		{json.Number("7"), int64(0), int64(7)},
+		{json.Number("1.5"), float64(0), 1.5},
+		{"12", gold(0), gold(12)},
+		{"x", int(0), nil},
+		{300, int8(0), nil},
+		{-1, uint(0), nil},
+		{int32(2), float32(0), float32(2)},
+		{true, int(0), nil},
+		// the integers above 2^53 are converted exactly.
+		{int(9007199254740993), int64(0), int64(9007199254740993)},
+		{uint64(1<<63 - 1), int64(0), int64(1<<63 - 1)},
+		{uint64(1 << 63), int64(0), nil},
+		{int64(math.MaxInt64), int64(0), int64(math.MaxInt64)},
+		{uint64(math.MaxUint64), uint64(0), uint64(math.MaxUint64)},
+		{json.Number("18446744073709551615"), uint64(0), uint64(math.MaxUint64)},
+		{int64(9007199254740993), float64(0), nil},
+		{float64(1 << 63), int64(0), nil},
+		{float64(-1 << 63), int64(0), int64(-1 << 63)},
+	}
+	for _, c := range cases {
+		val, ok := coerceParam(c.param, reflect.TypeOf(c.typ))
+		assert.Equal(t, c.want != nil, ok, "%v", c.param)
+		assert.Equal(t, c.want, val, "%v", c.param)
+	}
+}
+
+func TestNamedCall(t *testing.T) {
+	p := NewPost(uint64(64), 1)
+	p.Register("add", func(a, b int) int { return a + b })
+	err := p.PutQueue("add", "1", 2)
+	assert.True(t, errors.Is(err, ErrBadParams))
+	_, err = p.Submit("add", 1).Wait(time.Second)
+	assert.True(t, errors.Is(err, ErrBadParams))
+
+	p.Functions.SetCoercion(true)
+	rets, err := p.Submit("add", "1", json.Number("2")).Wait(time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{3}, rets)
+
+	// a nil parameter is the zero value of the type.
+	rets, err = p.Submit(func(v interface{}) bool { return v == nil }, nil).Wait(time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{true}, rets)
+	p.Close()
+
+	// the parameters are checked before call if they're put to the object.
+	obj := newOverflowObject(OVERFLOW_FAIL, 0)
+	obj.Register("add", func(a, b int) int { return a + b })
+	future := obj.Submit("add", 1, 2, 3)
+	obj.ExecuteEvent()
+	_, err = future.Wait(time.Second)
+	assert.True(t, errors.Is(err, ErrBadParams))
+}
@@ -0,0 +1,158 @@
+// Runtime metrics of objects and job workers.
+package post
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// the upper bounds of the execution latency histogram, the size of histogram depends on it.
+	latencyBuckets = [...]time.Duration{
+		100 * time.Microsecond,
+		500 * time.Microsecond,
+		time.Millisecond,
+		5 * time.Millisecond,
+		10 * time.Millisecond,
+		50 * time.Millisecond,
+		100 * time.Millisecond,
+		500 * time.Millisecond,
+		time.Second,
+		5 * time.Second,
+	}
+)
+
+// Counters updated atomically by the producers and the consumer.
+type counters struct {
+	enqueued  uint64
+	executed  uint64
+	failed    uint64
+	panicked  uint64
+	putFailed uint64
+	dropped   uint64
+	retried   uint64
+	stolen    uint64
+	// the idle time in nanoseconds.
+	idle    int64
+	latency histogram
+}
+
+type histogram struct {
+	// the last one is for the latency above all buckets.
+	counts [len(latencyBuckets) + 1]uint64
+	count  uint64
+	sum    int64
+}
+
+// A snapshot of the latency histogram, counts are not cumulative.
+type Histogram struct {
+	Buckets []time.Duration
+	Counts  []uint64
+	Count   uint64
+	Sum     time.Duration
+}
+
+// A snapshot of an object or a job worker.
+type Stats struct {
+	// the number of jobs waiting in the queue.
+	Depth    uint64
+	Capacity uint64
+	Enqueued uint64
+	Executed uint64
+	// jobs which are cancelled, failed by the middlewares or whose function is not found.
+	Failed    uint64
+	Panicked  uint64
+	PutFailed uint64
+	Dropped   uint64
+	// the failed attempts rescheduled by retry policies.
+	Retried uint64
+	// the jobs taken out of the queue by the idle siblings.
+	Stolen   uint64
+	IdleTime time.Duration
+	Latency  Histogram
+}
+
+type PostStats struct {
+	Spec    Stats
+	Objects []Stats
+	// the job workers by group.
+	Groups map[string]Stats
+}
+
+func (this *histogram) observe(d time.Duration) {
+	i := 0
+	for i < len(latencyBuckets) && d > latencyBuckets[i] {
+		i++
+	}
+	atomic.AddUint64(&this.counts[i], 1)
+	atomic.AddUint64(&this.count, 1)
+	atomic.AddInt64(&this.sum, int64(d))
+}
+
+func (this *histogram) snapshot() Histogram {
+	h := Histogram{
+		Buckets: append([]time.Duration(nil), latencyBuckets[:]...),
+		Counts:  make([]uint64, len(latencyBuckets)+1),
+		Count:   atomic.LoadUint64(&this.count),
+		Sum:     time.Duration(atomic.LoadInt64(&this.sum)),
+	}
+	for i := range h.Counts {
+		h.Counts[i] = atomic.LoadUint64(&this.counts[i])
+	}
+	return h
+}
+
+// Record a finished job.
+func (this *counters) observe(d time.Duration, panicked bool) {
+	atomic.AddUint64(&this.executed, 1)
+	if panicked {
+		atomic.AddUint64(&this.panicked, 1)
+	}
+	this.latency.observe(d)
+}
+
+func (this *counters) snapshot(depth, capacity uint64) Stats {
+	return Stats{
+		Depth:     depth,
+		Capacity:  capacity,
+		Enqueued:  atomic.LoadUint64(&this.enqueued),
+		Executed:  atomic.LoadUint64(&this.executed),
+		Failed:    atomic.LoadUint64(&this.failed),
+		Panicked:  atomic.LoadUint64(&this.panicked),
+		PutFailed: atomic.LoadUint64(&this.putFailed),
+		Dropped:   atomic.LoadUint64(&this.dropped),
+		Retried:   atomic.LoadUint64(&this.retried),
+		Stolen:    atomic.LoadUint64(&this.stolen),
+		IdleTime:  time.Duration(atomic.LoadInt64(&this.idle)),
+		Latency:   this.latency.snapshot(),
+	}
+}
+
+func (this *RpcObject) Stats() Stats {
+	return this.stats.snapshot(this.depth(), this.capacity())
+}
+
+func (this *JobWorker) Stats() Stats {
+	return this.stats.snapshot(uint64(len(this.jobQueue)), uint64(cap(this.jobQueue)))
+}
+
+// Get the metrics of the running objects and all the job workers.
+func (this *Post) Stats() PostStats {
+	this.lock.Lock()
+	stats := PostStats{
+		Spec:    this.Object.Stats(),
+		Objects: make([]Stats, 0, this.index),
+	}
+	for _, o := range this.objects[:this.index] {
+		stats.Objects = append(stats.Objects, o.Stats())
+	}
+	this.lock.Unlock()
+
+	JobWorkersLock.RLock()
+	stats.Groups = make(map[string]Stats, len(JobWorkers))
+	for group, worker := range JobWorkers {
+		stats.Groups[group] = worker.Stats()
+	}
+	JobWorkersLock.RUnlock()
+	return stats
+}
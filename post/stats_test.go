@@ -0,0 +1,33 @@
+package post
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+	obj := newOverflowObject(OVERFLOW_DROP_NEWEST, 0)
+	for i := 0; i < 16; i++ {
+		obj.PutQueue(func() {}, false)
+	}
+	obj.PutQueue("missing", false)
+	stats := obj.Stats()
+	assert.Equal(t, uint64(14), stats.Depth)
+	assert.Equal(t, uint64(14), stats.Enqueued)
+	assert.Equal(t, uint64(3), stats.Dropped)
+
+	obj.ExecuteEvent()
+	obj.PutQueue(func() { time.Sleep(2 * time.Millisecond) }, false)
+	obj.ExecuteEvent()
+	stats = obj.Stats()
+	assert.Equal(t, uint64(0), stats.Depth)
+	assert.Equal(t, uint64(15), stats.Executed)
+	assert.Equal(t, uint64(15), stats.Latency.Count)
+	assert.Equal(t, true, stats.Latency.Sum >= 2*time.Millisecond)
+
+	GPost.PutJob("testStatsGroup", func() {})
+	time.Sleep(1 * time.Millisecond)
+	assert.Equal(t, uint64(1), GPost.Stats().Groups["testStatsGroup"].Executed)
+}
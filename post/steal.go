@@ -0,0 +1,112 @@
+// Work stealing between the objects of Post, an idle object takes a portion of the jobs of the busiest sibling.
+package post
+
+import (
+	"log"
+	"sync/atomic"
+)
+
+const (
+	// the default minimum number of jobs waiting in a sibling to steal from it.
+	STEAL_THRESHOLD = 16
+	// the default maximum number of jobs stolen at a time.
+	STEAL_BATCH_NUM = 64
+)
+
+// Jobs are stolen only from the objects of the same Post, the spec object is never involved.
+// Stealing is disabled for FuncAffinity since the jobs of a function must stay in one object,
+// the keyed jobs are ordered by their mailboxes, so they can be stolen, and the thief executes the drain jobs
+// of the mailboxes with its own stats.
+type StealConfig struct {
+	// the minimum number of jobs waiting in the victim, STEAL_THRESHOLD if it's zero.
+	Threshold uint64
+	// the maximum number of jobs stolen at a time, STEAL_BATCH_NUM if it's zero,
+	// half of the jobs waiting in the victim are stolen at most.
+	Batch uint64
+}
+
+type stealer struct {
+	config StealConfig
+	post   *Post
+}
+
+// Enable work stealing between the running objects, nil disables it.
+// The idle objects look for the victim before parking and after each poll interval.
+func (this *Post) SetWorkStealing(config *StealConfig) {
+	defer this.lock.Unlock()
+	this.lock.Lock()
+	this.stealer = nil
+	if config != nil {
+		if _, ok := this.dispatcher.(*funcAffinity); ok {
+			log.Printf("[WorkStealing] disabled for FuncAffinity\n")
+		} else {
+			this.stealer = newStealer(this, *config)
+		}
+	}
+	for _, o := range this.objects {
+		o.stealer.Store(this.stealer)
+	}
+}
+
+func newStealer(p *Post, config StealConfig) *stealer {
+	if config.Threshold == 0 {
+		config.Threshold = STEAL_THRESHOLD
+	}
+	if config.Batch == 0 {
+		config.Batch = STEAL_BATCH_NUM
+	}
+	return &stealer{config: config, post: p}
+}
+
+// The running sibling with the most jobs waiting in the lanes, nil if none reaches the threshold.
+func (this *stealer) victim(thief *RpcObject) (victim *RpcObject, quantity uint64) {
+	for _, o := range this.post.runningObjects() {
+		if o == thief {
+			continue
+		}
+		if n := o.quantity(); n >= this.config.Threshold && n > quantity {
+			victim, quantity = o, n
+		}
+	}
+	return
+}
+
+// Take a portion of the jobs of the busiest sibling and execute them, it returns the number of jobs stolen.
+// The jobs are taken by priority, and the ones in the overflow slice of the victim are left.
+func (this *RpcObject) steal() (total uint64) {
+	s := this.stealer.Load()
+	if s == nil {
+		return
+	}
+	victim, quantity := s.victim(this)
+	if victim == nil {
+		return
+	}
+	num := quantity / 2
+	if num > s.config.Batch {
+		num = s.config.Batch
+	}
+	if num > uint64(len(this.Vals)) {
+		num = uint64(len(this.Vals))
+	}
+	for _, lane := range laneOrder {
+		if total >= num {
+			break
+		}
+		buf := this.Vals[:num-total]
+		cnt, _ := victim.lanes[lane].Gets(buf)
+		if cnt == 0 {
+			continue
+		}
+		victim.notifySpace()
+		atomic.AddUint64(&victim.stats.stolen, cnt)
+		total += cnt
+		for i := uint64(0); i < cnt; i++ {
+			atomic.StoreInt64(&this.batched, int64(cnt-i-1))
+			msg := buf[i].(*QueueMsg)
+			buf[i] = nil
+			this.executeMsg(msg)
+		}
+	}
+	return
+}
@@ -0,0 +1,53 @@
+package post
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkStealing(t *testing.T) {
+	p := NewPost(uint64(1024), 2, WithWorkStealing(StealConfig{Threshold: 2}))
+	defer p.Close()
+	busy := p.objects[0]
+	block := make(chan struct{})
+	defer close(block)
+	busy.PutQueue(func() { <-block }, false)
+	time.Sleep(2 * MAX_SLEEP_TIME)
+
+	var done int32
+	for i := 0; i < 32; i++ {
+		busy.PutQueue(func() { atomic.AddInt32(&done, 1) }, false)
+	}
+	time.Sleep(10 * MAX_SLEEP_TIME)
+	// the jobs behind the blocked one are executed by the idle sibling, except the last one.
+	assert.True(t, atomic.LoadInt32(&done) >= 30)
+	stolen := uint64(atomic.LoadInt32(&done))
+	assert.Equal(t, stolen, busy.Stats().Stolen)
+	assert.Equal(t, stolen, p.objects[1].Stats().Executed)
+
+	// the spec object is never stolen from.
+	spec := make(chan struct{})
+	p.PutQueueSpec(func() { <-spec })
+	var specDone int32
+	for i := 0; i < 8; i++ {
+		p.PutQueueSpec(func() { atomic.AddInt32(&specDone, 1) })
+	}
+	time.Sleep(5 * MAX_SLEEP_TIME)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&specDone))
+	close(spec)
+	time.Sleep(5 * MAX_SLEEP_TIME)
+	assert.Equal(t, int32(8), atomic.LoadInt32(&specDone))
+
+	p.SetWorkStealing(nil)
+	assert.Nil(t, busy.stealer.Load())
+}
+
+func TestWorkStealingFuncAffinity(t *testing.T) {
+	p := NewPost(uint64(64), 2, WithDispatcher(FuncAffinity()), WithWorkStealing(StealConfig{}))
+	defer p.Close()
+	assert.Nil(t, p.objects[0].stealer.Load())
+	assert.Nil(t, p.objects[1].stealer.Load())
+}
@@ -0,0 +1,219 @@
+// Tracing of jobs, the trace context is carried by the context of jobs across objects, job workers and timers.
+package post
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/TianQinS/fastapi/basic"
+)
+
+const (
+	// the default number of spans kept by MemoryTraceExporter.
+	TRACE_MEMORY_CAPACITY = 4096
+	// the name of the spans for callbacks.
+	SPAN_CALLBACK = "callback"
+)
+
+var (
+	// spans are discarded if no exporter is set.
+	traceExporter atomic.Value
+)
+
+type traceKey struct{}
+
+// The identity of a span, the child spans share the trace id.
+type TraceContext struct {
+	TraceID  string
+	SpanID   string
+	ParentID string
+}
+
+// A finished span of a job, a callback or a timer entry.
+type SpanRecord struct {
+	TraceID  string `json:"trace_id"`
+	SpanID   string `json:"span_id"`
+	ParentID string `json:"parent_id,omitempty"`
+	// the function name or the id for named calls.
+	Name  string `json:"name"`
+	Group string `json:"group,omitempty"`
+	// the time the job was enqueued, the wait in queue and the execution time.
+	Enqueue time.Time     `json:"enqueue"`
+	Wait    time.Duration `json:"wait"`
+	Exec    time.Duration `json:"exec"`
+	Attempt int           `json:"attempt,omitempty"`
+	Err     string        `json:"err,omitempty"`
+}
+
+// TraceExporter receives the finished spans, it must be safe for concurrent use.
+type TraceExporter interface {
+	Export(span SpanRecord)
+}
+
+// Keep the latest spans in memory.
+type MemoryTraceExporter struct {
+	ring *basic.Ring[SpanRecord]
+}
+
+// Write the spans to a file as JSON lines.
+type FileTraceExporter struct {
+	lines *basic.JSONLines
+}
+
+func spanID() string {
+	return fmt.Sprintf("%016x", rand.Uint64())
+}
+
+// Start a new trace.
+func NewTrace() *TraceContext {
+	return &TraceContext{
+		TraceID: fmt.Sprintf("%016x%016x", rand.Uint64(), rand.Uint64()),
+		SpanID:  spanID(),
+	}
+}
+
+// A new span of the same trace whose parent is this one.
+func (this *TraceContext) Child() *TraceContext {
+	return &TraceContext{
+		TraceID:  this.TraceID,
+		SpanID:   spanID(),
+		ParentID: this.SpanID,
+	}
+}
+
+// The jobs put with the context are traced as the children of tc.
+func WithTrace(ctx context.Context, tc *TraceContext) context.Context {
+	return context.WithValue(ctx, traceKey{}, tc)
+}
+
+func TraceFromContext(ctx context.Context) *TraceContext {
+	if ctx == nil {
+		return nil
+	}
+	tc, _ := ctx.Value(traceKey{}).(*TraceContext)
+	return tc
+}
+
+// Set the exporter of spans, nil means tracing is disabled.
+func SetTraceExporter(exporter TraceExporter) {
+	traceExporter.Store(&exporter)
+}
+
+// Export the span if an exporter is set, it's used by the timer for its entries.
+func ExportSpan(span SpanRecord) {
+	if exporter, _ := traceExporter.Load().(*TraceExporter); exporter != nil && *exporter != nil {
+		(*exporter).Export(span)
+	}
+}
+
+// The span of the trace context.
+func (this *TraceContext) record(name string, enqueue time.Time, wait, exec time.Duration, err error) SpanRecord {
+	span := SpanRecord{
+		TraceID:  this.TraceID,
+		SpanID:   this.SpanID,
+		ParentID: this.ParentID,
+		Name:     name,
+		Enqueue:  enqueue,
+		Wait:     wait,
+		Exec:     exec,
+	}
+	if err != nil {
+		span.Err = err.Error()
+	}
+	return span
+}
+
+// Trace the message as a child of the trace in its context, and record the enqueue time.
+func (this *QueueMsg) trace() {
+	if this.Trace == nil {
+		if this.Trace = TraceFromContext(this.Ctx); this.Trace != nil {
+			this.Trace = this.Trace.Child()
+		}
+	}
+	if this.Trace != nil {
+		this.enqueued = time.Now().UnixNano()
+	}
+}
+
+// Export the span of the job started at start.
+func (this *Job) export(start time.Time, err error) {
+	msg := this.Msg
+	enqueue := time.Unix(0, msg.enqueued)
+	span := msg.Trace.record(this.Name(), enqueue, start.Sub(enqueue), time.Now().Sub(start), err)
+	span.Group = this.Group
+	span.Attempt = msg.Attempt
+	ExportSpan(span)
+}
+
+// Call the callback of the message in a child span.
+func (this *QueueMsg) callback(call func()) {
+	if this.Trace == nil {
+		call()
+		return
+	}
+	start := time.Now()
+	defer func() {
+		info := recover()
+		var err error
+		if info != nil {
+			err = basic.PanicError(info)
+		}
+		ExportSpan(this.Trace.Child().record(SPAN_CALLBACK+":"+funcName(this.Callback), start, 0, time.Now().Sub(start), err))
+		if info != nil {
+			panic(info)
+		}
+	}()
+	call()
+}
+
+func NewMemoryTraceExporter(capacity int) *MemoryTraceExporter {
+	if capacity <= 0 {
+		capacity = TRACE_MEMORY_CAPACITY
+	}
+	return &MemoryTraceExporter{ring: basic.NewRing[SpanRecord](capacity)}
+}
+
+func (this *MemoryTraceExporter) Export(span SpanRecord) {
+	this.ring.Add(span)
+}
+
+// The spans from the oldest to the latest.
+func (this *MemoryTraceExporter) Spans() []SpanRecord {
+	return this.ring.List()
+}
+
+// The spans of the trace in the order they're finished.
+func (this *MemoryTraceExporter) Trace(traceID string) (spans []SpanRecord) {
+	for _, span := range this.Spans() {
+		if span.TraceID == traceID {
+			spans = append(spans, span)
+		}
+	}
+	return
+}
+
+func (this *MemoryTraceExporter) Reset() {
+	this.ring.Clear()
+}
+
+func NewFileTraceExporter(filePath string) (*FileTraceExporter, error) {
+	lines, err := basic.NewJSONLines(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return &FileTraceExporter{lines: lines}, nil
+}
+
+func (this *FileTraceExporter) Export(span SpanRecord) {
+	if err := this.lines.Write(span); err != nil {
+		log.Printf("[FileTraceExporter] %v\n", err)
+	}
+}
+
+func (this *FileTraceExporter) Close() error {
+	return this.lines.Close()
+}
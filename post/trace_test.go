@@ -0,0 +1,112 @@
+package post
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrace(t *testing.T) {
+	exporter := NewMemoryTraceExporter(0)
+	SetTraceExporter(exporter)
+	defer SetTraceExporter(nil)
+
+	p := NewPost(uint64(64), 1)
+	defer p.Close()
+	root := NewTrace()
+	ctx := WithTrace(context.Background(), root)
+	done := make(chan struct{})
+	assert.Nil(t, p.PutQueueWithCallbackCtx(ctx, func(ctx context.Context) int {
+		// the nested job is a child of this one.
+		p.PutQueueCtx(ctx, func() {
+			time.Sleep(time.Millisecond)
+			close(done)
+		})
+		return 1
+	}, func(n int) {}, nil))
+	<-done
+	// untraced jobs are not exported.
+	p.Submit(func() {}).Wait(time.Second)
+	time.Sleep(2 * MAX_SLEEP_TIME)
+
+	spans := exporter.Trace(root.TraceID)
+	assert.Equal(t, 3, len(spans))
+	assert.Equal(t, len(spans), len(exporter.Spans()))
+	byParent := map[string]SpanRecord{}
+	for _, span := range spans {
+		byParent[span.ParentID] = span
+	}
+	job := byParent[root.SpanID]
+	assert.NotEqual(t, "", job.SpanID)
+	assert.True(t, job.Wait >= 0 && job.Exec > 0)
+	children := 0
+	for _, span := range spans {
+		if span.ParentID == job.SpanID {
+			children++
+		}
+	}
+	// the callback and the nested job.
+	assert.Equal(t, 2, children)
+
+	exporter.Reset()
+	assert.Equal(t, 0, len(exporter.Spans()))
+}
+
+func TestTraceGroup(t *testing.T) {
+	exporter := NewMemoryTraceExporter(2)
+	SetTraceExporter(exporter)
+	defer SetTraceExporter(nil)
+
+	root := NewTrace()
+	ctx := WithTrace(context.Background(), root)
+	p := NewPost(uint64(64), 1)
+	defer p.Close()
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, p.PutJobCtx(ctx, "trace", func(i int) {
+			if i == 2 {
+				panic("bad")
+			}
+		}, i))
+	}
+	time.Sleep(2 * MAX_SLEEP_TIME)
+	// the oldest span is dropped.
+	spans := exporter.Spans()
+	assert.Equal(t, 2, len(spans))
+	assert.Equal(t, "trace", spans[1].Group)
+	assert.Equal(t, root.SpanID, spans[1].ParentID)
+	assert.Contains(t, spans[1].Err, "bad")
+	CloseGroup("trace")
+}
+
+func TestFileTraceExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.log")
+	exporter, err := NewFileTraceExporter(path)
+	assert.Nil(t, err)
+	SetTraceExporter(exporter)
+	defer SetTraceExporter(nil)
+
+	obj := newOverflowObject(OVERFLOW_FAIL, 0)
+	obj.Register("traced", func() {})
+	root := NewTrace()
+	obj.PutQueueCtx(WithTrace(context.Background(), root), "traced", false)
+	obj.ExecuteEvent()
+	assert.Nil(t, exporter.Close())
+
+	f, err := os.Open(path)
+	assert.Nil(t, err)
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	assert.True(t, scanner.Scan())
+	var span SpanRecord
+	assert.Nil(t, json.Unmarshal(scanner.Bytes(), &span))
+	assert.Equal(t, root.TraceID, span.TraceID)
+	assert.Equal(t, root.SpanID, span.ParentID)
+	assert.Equal(t, "traced", span.Name)
+	assert.False(t, scanner.Scan())
+}
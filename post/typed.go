@@ -0,0 +1,60 @@
+// Type-safe jobs without reflect, the arguments are captured by closures
+// which share the same queues and workers with other jobs.
+package post
+
+// Run f(a) in the routine pool.
+func Go[T any](p *Post, f func(T), a T) error {
+	return p.putFunc(func() {
+		f(a)
+	})
+}
+
+func Go2[A, B any](p *Post, f func(A, B), a A, b B) error {
+	return p.putFunc(func() {
+		f(a, b)
+	})
+}
+
+// Run f in the routine pool, cb is called with the result in the same routine if not nil.
+func Call0[R any](p *Post, f func() R, cb func(R)) error {
+	return p.putFunc(func() {
+		r := f()
+		if cb != nil {
+			cb(r)
+		}
+	})
+}
+
+func Call1[A, R any](p *Post, f func(A) R, a A, cb func(R)) error {
+	return p.putFunc(func() {
+		r := f(a)
+		if cb != nil {
+			cb(r)
+		}
+	})
+}
+
+func Call2[A, B, R any](p *Post, f func(A, B) R, a A, b B, cb func(R)) error {
+	return p.putFunc(func() {
+		r := f(a, b)
+		if cb != nil {
+			cb(r)
+		}
+	})
+}
+
+// Run f(a) by the job worker of the group.
+func GoJob[T any](p *Post, group string, f func(T), a T) error {
+	return p.putJobFunc(group, func() {
+		f(a)
+	})
+}
+
+func CallJob1[A, R any](p *Post, group string, f func(A) R, a A, cb func(R)) error {
+	return p.putJobFunc(group, func() {
+		r := f(a)
+		if cb != nil {
+			cb(r)
+		}
+	})
+}
@@ -0,0 +1,79 @@
+package post
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTyped(t *testing.T) {
+	p := NewPost(uint64(1024), 2)
+	a := int32(0)
+	b := make(chan string, 1)
+	err := Go(p, func(d *int32) {
+		atomic.StoreInt32(d, 1)
+	}, &a)
+	assert.Equal(t, nil, err)
+	time.Sleep(15 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&a))
+
+	err = Call2(p, func(x, y int) int {
+		return x + y
+	}, 1, 2, func(sum int) {
+		atomic.StoreInt32(&a, int32(sum))
+	})
+	assert.Equal(t, nil, err)
+	time.Sleep(15 * time.Millisecond)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&a))
+
+	CallJob1(p, "testTypedGroup", func(s string) string {
+		return s + s
+	}, "ab", func(s string) {
+		b <- s
+	})
+	select {
+	case s := <-b:
+		assert.Equal(t, "abab", s)
+	case <-time.After(time.Second):
+		t.Fatal("the callback isn't called")
+	}
+	p.Close()
+}
+
+func BenchmarkTyped(b *testing.B) {
+	p := NewPost(uint64(1024), 1)
+	obj := p.objects[0]
+	// stop the loop and execute events by the benchmark.
+	obj.stop()
+	time.Sleep(2 * MAX_SLEEP_TIME)
+	d := 0
+	for i := 0; i < b.N; i++ {
+		Go(p, func2Typed, &d)
+		obj.ExecuteEvent()
+	}
+}
+
+func func2Typed(d *int) {
+	*d = 1
+}
+
+func TestCallbackWithoutParams(t *testing.T) {
+	p := NewPost(uint64(64), 1)
+	defer p.Close()
+	done := make(chan string, 2)
+	cb := func(s string) { done <- s }
+	assert.Nil(t, p.PutQueueWithCallback(func() {}, cb, []interface{}{"queue"}))
+	assert.Nil(t, p.PutJobWithCallback("callback", func() {}, cb, []interface{}{"job"}))
+	var names []string
+	for i := 0; i < 2; i++ {
+		select {
+		case s := <-done:
+			names = append(names, s)
+		case <-time.After(time.Second):
+			t.Fatal("the callback isn't called")
+		}
+	}
+	assert.ElementsMatch(t, []string{"queue", "job"}, names)
+}
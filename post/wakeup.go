@@ -0,0 +1,72 @@
+// Wakeup of the object's loop, it spins briefly and then parks until a job is put.
+package post
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// spin times of the loop on an empty queue before parking.
+	LOOP_SPIN_NUM = 64
+)
+
+// The signal to wake up the parked loop.
+type wakeup struct {
+	// 1 while the loop is parked or going to park.
+	parked int32
+	signal chan struct{}
+	// the maximum time to park in nanoseconds, the queue is polled after it in case a signal is missed.
+	poll int64
+}
+
+func (this *wakeup) init() {
+	this.signal = make(chan struct{}, 1)
+	this.poll = int64(MAX_SLEEP_TIME)
+}
+
+// Set the maximum time the idle loop parks before polling the queue again,
+// a non-positive interval means MAX_SLEEP_TIME.
+func (this *RpcObject) SetPollInterval(interval time.Duration) {
+	if interval <= 0 {
+		interval = MAX_SLEEP_TIME
+	}
+	atomic.StoreInt64(&this.wakeup.poll, int64(interval))
+}
+
+// Wake up the parked loop, it's called by the producers after a job is put.
+// Only the first put after the loop parks on an empty queue sends the signal.
+func (this *RpcObject) wake() {
+	if atomic.LoadInt32(&this.wakeup.parked) == 1 && atomic.CompareAndSwapInt32(&this.wakeup.parked, 1, 0) {
+		this.wakeup.notify()
+	}
+}
+
+func (this *wakeup) notify() {
+	select {
+	case this.signal <- struct{}{}:
+	default:
+	}
+}
+
+// Wait for jobs after the queue is found empty, it spins for the first LOOP_SPIN_NUM times.
+func (this *RpcObject) idle(spin int) {
+	if spin < LOOP_SPIN_NUM {
+		runtime.Gosched()
+		return
+	}
+	start := time.Now()
+	atomic.StoreInt32(&this.wakeup.parked, 1)
+	// check again after parked in case a job is put before the flag is seen.
+	if this.depth() == 0 && this.IsRunning() {
+		t := time.NewTimer(time.Duration(atomic.LoadInt64(&this.wakeup.poll)))
+		select {
+		case <-this.wakeup.signal:
+		case <-t.C:
+		}
+		t.Stop()
+	}
+	atomic.StoreInt32(&this.wakeup.parked, 0)
+	atomic.AddInt64(&this.stats.idle, int64(time.Now().Sub(start)))
+}
@@ -0,0 +1,38 @@
+package post
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWakeup(t *testing.T) {
+	// the jobs can't wait for the poll interval.
+	p := NewPost(uint64(64), 1, WithPollInterval(time.Second))
+	defer p.Close()
+	for i := 0; i < 10; i++ {
+		time.Sleep(2 * time.Millisecond)
+		start := time.Now()
+		rets, err := p.Submit(func(i int) int { return i }, i).Wait(time.Second)
+		assert.Nil(t, err)
+		assert.Equal(t, []interface{}{i}, rets)
+		assert.True(t, time.Now().Sub(start) < 100*time.Millisecond)
+	}
+	assert.True(t, p.Stats().Objects[0].IdleTime > 0)
+}
+
+func TestWakeupClose(t *testing.T) {
+	o := &RpcObject{}
+	o.Init(64)
+	o.SetPollInterval(time.Second)
+	go o.Loop()
+	time.Sleep(2 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&o.wakeup.parked))
+
+	// the parked loop exits immediately.
+	o.Close()
+	time.Sleep(2 * MAX_SLEEP_TIME)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&o.running))
+}
@@ -0,0 +1,257 @@
+// Execution budgets of jobs and the watchdog for slow jobs.
+package post
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DEFAULT_WATCHDOG_INTERVAL = 100 * time.Millisecond
+	// the maximum size of all goroutine stacks dumped.
+	WATCHDOG_STACK_SIZE = 1 << 20
+)
+
+type WatchdogConfig struct {
+	// the interval between two checks.
+	Interval time.Duration
+	// the budget of the jobs without timeout, they aren't watched if it's zero.
+	Threshold time.Duration
+	// route the subsequent jobs away from the object whose job is stuck until it finishes.
+	Reroute bool
+	// the slow jobs are logged by default.
+	Report func(job SlowJob)
+}
+
+// The report of a job exceeding it's budget.
+type SlowJob struct {
+	Name string
+	// the job worker group, it's empty for the jobs of objects.
+	Group   string
+	Object  *RpcObject
+	Elapsed time.Duration
+	Budget  time.Duration
+	// the stack of the goroutine running the job.
+	Stack string
+}
+
+// The job running in an object or a job worker.
+type current struct {
+	msg atomic.Pointer[QueueMsg]
+	// the message is recycled after end, so it's read under the lock by the watchdog.
+	lock sync.Mutex
+	// the start time in nanoseconds, it's zero if no job is running.
+	start    int64
+	budget   int64
+	reported int32
+	// the stuck object is skipped by dispatching.
+	stuck int32
+	// the goroutine of the loop.
+	gid int64
+	// the goroutine is running.
+	alive int32
+}
+
+type watchdog struct {
+	config WatchdogConfig
+	stop   chan struct{}
+}
+
+func (this *current) begin(msg *QueueMsg, start time.Time) {
+	this.msg.Store(msg)
+	atomic.StoreInt64(&this.budget, int64(msg.Timeout))
+	atomic.StoreInt32(&this.reported, 0)
+	atomic.StoreInt64(&this.start, start.UnixNano())
+}
+
+func (this *current) end() {
+	atomic.StoreInt64(&this.start, 0)
+	atomic.StoreInt32(&this.stuck, 0)
+	this.lock.Lock()
+	this.msg.Store(nil)
+	this.lock.Unlock()
+}
+
+func (this *current) isStuck() bool {
+	return atomic.LoadInt32(&this.stuck) == 1
+}
+
+// The id of the current goroutine parsed from it's stack.
+func goroutineID() int64 {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	buf = bytes.TrimPrefix(buf, []byte("goroutine "))
+	if i := bytes.IndexByte(buf, ' '); i > 0 {
+		id, _ := strconv.ParseInt(string(buf[:i]), 10, 64)
+		return id
+	}
+	return 0
+}
+
+// The stack of the goroutine by id.
+func goroutineStack(id int64) string {
+	buf := make([]byte, WATCHDOG_STACK_SIZE)
+	buf = buf[:runtime.Stack(buf, true)]
+	prefix := []byte(fmt.Sprintf("goroutine %d [", id))
+	for _, stack := range bytes.Split(buf, []byte("\n\n")) {
+		if bytes.HasPrefix(stack, prefix) {
+			return string(stack)
+		}
+	}
+	return ""
+}
+
+// Set the default timeout of the registered function, it's removed if timeout is zero.
+func (this *Post) SetTimeout(id string, timeout time.Duration) {
+	if timeout <= 0 {
+		this.timeouts.Delete(id)
+		return
+	}
+	this.timeouts.Store(id, timeout)
+}
+
+// The timeout of the registered function is used if no timeout is specified for the job.
+func (this *Post) jobTimeout(msg *QueueMsg) {
+	if msg.Timeout != 0 {
+		return
+	}
+	if id, ok := msg.Func.(string); ok {
+		if timeout, ok := this.timeouts.Load(id); ok {
+			msg.Timeout = timeout.(time.Duration)
+		}
+	}
+}
+
+// Call a function with the timeout, ctx is done after the timeout if f accepts a context.Context,
+// and the watchdog reports the job if it's still running.
+func (this *Post) PutQueueTimeout(timeout time.Duration, f interface{}, params ...interface{}) error {
+	o := this.pick(f)
+	if o == nil {
+		return this.noWorkersError()
+	}
+	msg := o.newMsg(f, nil, params, nil, false)
+	msg.Timeout = timeout
+	return this.putMsg(o, msg)
+}
+
+func (this *Post) PutJobTimeout(group string, timeout time.Duration, f interface{}, params ...interface{}) error {
+	msg := newJobMsg(f, nil, params, nil, false)
+	msg.Timeout = timeout
+	return appendGroupJob(nil, group, msg)
+}
+
+// Start the watchdog for the objects of the post and all job workers.
+func (this *Post) StartWatchdog(config WatchdogConfig) {
+	if config.Interval <= 0 {
+		config.Interval = DEFAULT_WATCHDOG_INTERVAL
+	}
+	if config.Report == nil {
+		config.Report = func(job SlowJob) {
+			log.Printf("[Watchdog] slow job %s group=%s elapsed=%v budget=%v\n%s\n", job.Name, job.Group, job.Elapsed, job.Budget, job.Stack)
+		}
+	}
+	w := &watchdog{
+		config: config,
+		stop:   make(chan struct{}),
+	}
+	this.lock.Lock()
+	this.stopWatchdog()
+	this.watchdog = w
+	this.lock.Unlock()
+	go w.loop(this)
+}
+
+func (this *Post) StopWatchdog() {
+	defer this.lock.Unlock()
+	this.lock.Lock()
+	this.stopWatchdog()
+}
+
+// Must be called with the lock.
+func (this *Post) stopWatchdog() {
+	if this.watchdog != nil {
+		close(this.watchdog.stop)
+		this.watchdog = nil
+	}
+}
+
+func (this *watchdog) loop(p *Post) {
+	ticker := time.NewTicker(this.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-this.stop:
+			return
+		case <-ticker.C:
+			this.check(p)
+		}
+	}
+}
+
+func (this *watchdog) check(p *Post) {
+	now := time.Now()
+	p.lock.Lock()
+	objects := append([]*RpcObject{p.Object}, p.objects...)
+	p.lock.Unlock()
+	for _, o := range objects {
+		if job, ok := this.inspect(&o.current, now); ok {
+			job.Object = o
+			if this.config.Reroute && o != p.Object {
+				atomic.StoreInt32(&o.current.stuck, 1)
+			}
+			this.config.Report(job)
+		}
+	}
+
+	JobWorkersLock.RLock()
+	workers := make(map[string]*JobWorker, len(JobWorkers))
+	for group, worker := range JobWorkers {
+		workers[group] = worker
+	}
+	JobWorkersLock.RUnlock()
+	for group, worker := range workers {
+		for _, c := range worker.currents {
+			if job, ok := this.inspect(c, now); ok {
+				job.Group = group
+				this.config.Report(job)
+			}
+		}
+	}
+}
+
+// Check the running job, a slow job is reported only once.
+func (this *watchdog) inspect(c *current, now time.Time) (job SlowJob, ok bool) {
+	start := atomic.LoadInt64(&c.start)
+	if start == 0 {
+		return
+	}
+	budget := time.Duration(atomic.LoadInt64(&c.budget))
+	if budget <= 0 {
+		budget = this.config.Threshold
+	}
+	elapsed := now.Sub(time.Unix(0, start))
+	if budget <= 0 || elapsed <= budget || !atomic.CompareAndSwapInt32(&c.reported, 0, 1) {
+		return
+	}
+	c.lock.Lock()
+	msg := c.msg.Load()
+	if msg == nil {
+		c.lock.Unlock()
+		return
+	}
+	name := funcName(msg.Func)
+	c.lock.Unlock()
+	job = SlowJob{
+		Name:    name,
+		Elapsed: elapsed,
+		Budget:  budget,
+		Stack:   goroutineStack(atomic.LoadInt64(&c.gid)),
+	}
+	return job, true
+}
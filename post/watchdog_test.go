@@ -0,0 +1,72 @@
+package post
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchdog(t *testing.T) {
+	var lock sync.Mutex
+	jobs := []SlowJob{}
+	p := NewPost(uint64(64), 2)
+	p.StartWatchdog(WatchdogConfig{
+		Interval: 2 * time.Millisecond,
+		Reroute:  true,
+		Report: func(job SlowJob) {
+			lock.Lock()
+			jobs = append(jobs, job)
+			lock.Unlock()
+		},
+	})
+
+	block := make(chan struct{})
+	done := make(chan error, 1)
+	stuck := func(ctx context.Context) {
+		<-ctx.Done()
+		done <- ctx.Err()
+		<-block
+	}
+	p.Register("stuck", stuck)
+	p.SetTimeout("stuck", 5*time.Millisecond)
+	assert.Nil(t, p.PutQueue("stuck"))
+	time.Sleep(5 * MAX_SLEEP_TIME)
+
+	lock.Lock()
+	assert.Equal(t, 1, len(jobs))
+	job := jobs[0]
+	lock.Unlock()
+	assert.Equal(t, context.DeadlineExceeded, <-done)
+	assert.Equal(t, "stuck", job.Name)
+	assert.Equal(t, 5*time.Millisecond, job.Budget)
+	assert.True(t, job.Elapsed > job.Budget)
+	assert.True(t, strings.Contains(job.Stack, "TestWatchdog"))
+	// the subsequent jobs are routed to the other object.
+	for i := 0; i < 4; i++ {
+		assert.NotEqual(t, job.Object, p.pick(nil))
+	}
+
+	close(block)
+	time.Sleep(2 * MAX_SLEEP_TIME)
+	assert.False(t, job.Object.current.isStuck())
+
+	block = make(chan struct{})
+	assert.Nil(t, p.PutJobTimeout("watchdog", time.Millisecond, func() { <-block }))
+	time.Sleep(5 * MAX_SLEEP_TIME)
+	lock.Lock()
+	assert.Equal(t, 2, len(jobs))
+	assert.Equal(t, "watchdog", jobs[1].Group)
+	lock.Unlock()
+	close(block)
+	p.Close()
+}
+
+func TestGoroutineID(t *testing.T) {
+	id := goroutineID()
+	assert.True(t, id > 0)
+	assert.True(t, strings.Contains(goroutineStack(id), "TestGoroutineID"))
+}
@@ -2,12 +2,11 @@
 package post
 
 import (
-	"fmt"
+	"context"
 	"log"
-	"reflect"
 	"sync"
-
-	"github.com/TianQinS/fastapi/basic"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -16,28 +15,73 @@ const (
 )
 
 var (
-	JobWorkersLock       sync.RWMutex
-	numJobWorkersRunning sync.WaitGroup
+	JobWorkersLock sync.RWMutex
 	// create a default object.
 	JobWorkers = map[string]*JobWorker{}
+	// no more job workers can be created after shutdown.
+	jobWorkersClosed int32
 )
 
 type JobWorker struct {
-	jobQueue chan QueueMsg
+	group    string
+	config   GroupConfig
+	jobQueue chan *QueueMsg
+	// the senders blocked by a full queue are released when quit is closed.
+	lock     sync.RWMutex
+	closed   bool
+	quit     chan struct{}
+	quitOnce sync.Once
+	// the remaining jobs are dropped after aborted.
+	aborted int32
+	// the worker is removed from JobWorkers after it's idle for a while.
+	reaped int32
+	exit   chan struct{}
+	// the goroutines to wait for before exit.
+	wg sync.WaitGroup
+	// the jobs running in each goroutine, the elastic ones are after the fixed ones.
+	currents []*current
+	// mailboxes for key-ordered jobs.
+	keyed *keyedRouter
+	// the time of the last activity in nanoseconds.
+	active int64
+	stats  counters
 }
 
-// A job worker will create a goroutine with the memory consumption of the jobQueue.
-func newJobWorker() *JobWorker {
+// A job worker will create goroutines with the memory consumption of the jobQueue.
+func newJobWorker(group string, config GroupConfig) *JobWorker {
 	worker := &JobWorker{
-		jobQueue: make(chan QueueMsg, ASYNC_JOB_QUEUE_MAXLEN),
+		group:    group,
+		config:   config,
+		jobQueue: make(chan *QueueMsg, config.BufferSize),
+		quit:     make(chan struct{}),
+		exit:     make(chan struct{}),
+		currents: make([]*current, config.MaxWorkers),
+		keyed:    newKeyedRouter(),
+		active:   time.Now().UnixNano(),
+	}
+	for i := range worker.currents {
+		worker.currents[i] = &current{}
+	}
+	for _, cur := range worker.currents[:config.Workers] {
+		cur.alive = 1
+		worker.wg.Add(1)
+		go worker.loop(cur)
+	}
+	go func() {
+		worker.wg.Wait()
+		close(worker.exit)
+	}()
+	if config.IdleTimeout > 0 {
+		go worker.reap()
 	}
-	numJobWorkersRunning.Add(1)
-	go worker.loop()
 	return worker
 }
 
 // Gets or creates a worker with the name you specify
-func getJobWorker(group string) (worker *JobWorker) {
+func getJobWorker(group string) (worker *JobWorker, err error) {
+	if atomic.LoadInt32(&jobWorkersClosed) == 1 {
+		return nil, ErrClosed
+	}
 	// The read lock.
 	JobWorkersLock.RLock()
 	worker, _ = JobWorkers[group]
@@ -45,87 +89,191 @@ func getJobWorker(group string) (worker *JobWorker) {
 
 	if worker == nil {
 		JobWorkersLock.Lock()
-		worker = newJobWorker()
-		log.Printf("[NewJobWorker] group=%s\n", group)
-		JobWorkers[group] = worker
-		JobWorkersLock.Unlock()
+		defer JobWorkersLock.Unlock()
+		// check again in case the worker is created meanwhile.
+		if worker = JobWorkers[group]; worker == nil {
+			if atomic.LoadInt32(&jobWorkersClosed) == 1 {
+				return nil, ErrClosed
+			}
+			worker = newJobWorker(group, DefaultGroupConfig.withDefaults())
+			log.Printf("[NewJobWorker] group=%s\n", group)
+			JobWorkers[group] = worker
+		}
 	}
 	return
 }
 
-func (this *JobWorker) loop() {
-	defer numJobWorkersRunning.Done()
+// Append the job to the group, it's retried if the worker is reaped meanwhile.
+func appendGroupJob(ctx context.Context, group string, msg *QueueMsg) (err error) {
+	for {
+		var worker *JobWorker
+		if worker, err = getJobWorker(group); err != nil {
+			return
+		}
+		if err = worker.appendJob(ctx, msg); err != ErrClosed || atomic.LoadInt32(&worker.reaped) == 0 {
+			return
+		}
+	}
+}
+
+func (this *JobWorker) loop(cur *current) {
+	defer this.wg.Done()
+	atomic.StoreInt64(&cur.gid, goroutineID())
+	last := time.Now()
 	for msg := range this.jobQueue {
-		_runFunc := func() {
-			defer func() {
-				info := recover()
-				switch info.(type) {
-				case error:
-					basic.PackErrorMsg(info.(error), msg)
-				case string:
-					basic.PackErrorMsg(fmt.Errorf("%s", info.(string)), msg)
-				default:
-					if info != nil {
-						basic.PackErrorMsg(fmt.Errorf("%+v", info), msg)
-					}
-				}
-			}()
-
-			if msg.StrictUnReflect {
-				msg.Func.(func(args ...interface{}))(msg.Params...)
-			} else {
-				_f := reflect.ValueOf(msg.Func)
-				in := make([]reflect.Value, len(msg.Params))
-				for k := range in {
-					in[k] = reflect.ValueOf(msg.Params[k])
-				}
-
-				rets := _f.Call(in)
-				if cb := msg.Callback; cb != nil {
-					_f = reflect.ValueOf(cb)
-					params := msg.CallbackParams
-					if params != nil {
-						for k := range params {
-							rets = append([]reflect.Value{reflect.ValueOf(params[k])}, rets...)
-						}
-					}
-					_f.Call(rets)
-				}
-			}
+		atomic.AddInt64(&this.stats.idle, int64(time.Now().Sub(last)))
+		this.execute(msg, cur)
+		last = time.Now()
+	}
+}
+
+func (this *JobWorker) execute(msg *QueueMsg, cur *current) {
+	if atomic.LoadInt32(&this.aborted) == 1 {
+		atomic.AddUint64(&this.stats.dropped, 1)
+		msg.resolve(nil, ErrClosed)
+		recycleMsg(&jobMsgPool, msg)
+		return
+	}
+	job := &msg.job
+	*job = Job{Msg: msg, Func: msg.Func, Group: this.group, Args: msg.Params}
+	if letter := runMsg(job, this.handler(), &this.stats, cur); letter != nil {
+		this.deadLetter(letter)
+	}
+	recycleMsg(&jobMsgPool, msg)
+	atomic.StoreInt64(&this.active, time.Now().UnixNano())
+}
+
+// Append a job to the queue, it stops waiting for a full queue if ctx is done or the worker is closed.
+func (this *JobWorker) appendJob(ctx context.Context, msg *QueueMsg) (err error) {
+	if ctx != nil {
+		msg.Ctx = ctx
+	}
+	msg.trace()
+	if ok, err := this.limit(msg); !ok {
+		return err
+	}
+	return this.sendJob(msg)
+}
+
+// Send the job to the queue without the limits, it's used by the internal jobs such as the drain jobs of mailboxes.
+func (this *JobWorker) sendJob(msg *QueueMsg) (err error) {
+	var done <-chan struct{}
+	if msg.Ctx != nil {
+		done = msg.Ctx.Done()
+	}
+	defer func() {
+		if err != nil {
+			msg.release()
 		}
-		_runFunc()
+	}()
+
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	if this.closed {
+		atomic.AddUint64(&this.stats.putFailed, 1)
+		return ErrClosed
+	}
+	select {
+	case this.jobQueue <- msg:
+		atomic.AddUint64(&this.stats.enqueued, 1)
+		atomic.StoreInt64(&this.active, time.Now().UnixNano())
+		if len(this.jobQueue) > 0 && this.config.MaxWorkers > this.config.Workers {
+			this.grow()
+		}
+		return nil
+	case <-this.quit:
+		atomic.AddUint64(&this.stats.putFailed, 1)
+		return ErrClosed
+	case <-done:
+		atomic.AddUint64(&this.stats.putFailed, 1)
+		return msg.Ctx.Err()
 	}
 }
 
-func (this *JobWorker) appendJob(f interface{}, strictUnReflect bool, params []interface{}) {
-	this.jobQueue <- QueueMsg{f, nil, params, nil, strictUnReflect}
+// Append a job without waiting, it fails if the queue is full or the worker is closed.
+func (this *JobWorker) offerJob(msg *QueueMsg) bool {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	if this.closed {
+		return false
+	}
+	select {
+	case this.jobQueue <- msg:
+		atomic.AddUint64(&this.stats.enqueued, 1)
+		return true
+	default:
+		return false
+	}
+}
+
+// Stop accepting new jobs, the loop exits after the remaining jobs are finished.
+func (this *JobWorker) close() {
+	this.quitOnce.Do(func() {
+		close(this.quit)
+	})
+	this.lock.Lock()
+	if !this.closed {
+		this.closed = true
+		close(this.jobQueue)
+	}
+	this.lock.Unlock()
 }
 
-func (this *JobWorker) appendJobWithCallback(f, cb interface{}, cbParams, params []interface{}) {
-	this.jobQueue <- QueueMsg{f, cb, params, cbParams, false}
+// Drop the remaining jobs, it returns the number of them.
+func (this *JobWorker) abort() int {
+	atomic.StoreInt32(&this.aborted, 1)
+	return len(this.jobQueue)
+}
+
+// Close all the job workers and wait for them until ctx is done.
+func closeJobWorkers(ctx context.Context) (cleared bool, dropped int, err error) {
+	JobWorkersLock.Lock()
+	workers := JobWorkers
+	JobWorkers = map[string]*JobWorker{}
+	JobWorkersLock.Unlock()
+
+	for group, worker := range workers {
+		worker.close()
+		log.Printf("Clear %s\n", group)
+	}
+	// wait for all job workers to quit
+	for _, worker := range workers {
+		select {
+		case <-worker.exit:
+		case <-ctx.Done():
+			err = ctx.Err()
+			for _, w := range workers {
+				dropped += w.abort()
+			}
+			return len(workers) > 0, dropped, err
+		}
+	}
+	return len(workers) > 0, 0, nil
 }
 
 func Close() bool {
-	var cleared bool
 	// Close the global gorountine pool.
 	if GPost != nil {
 		GPost.Close()
 	}
 	// Close all job queue workers
 	log.Println("Waiting for all async job workers to be cleared ...")
-	JobWorkersLock.Lock()
-	if len(JobWorkers) > 0 {
-		for group, worker := range JobWorkers {
-			close(worker.jobQueue)
-			log.Printf("Clear %s\n", group)
-		}
-		JobWorkers = map[string]*JobWorker{}
-		cleared = true
-	}
-	JobWorkersLock.Unlock()
-
-	// numJobWorkersRunning.Done() as numJobWorkersRunning.Add(-1)
-	// wait for all job workers to quit
-	numJobWorkersRunning.Wait()
+	cleared, _, _ := closeJobWorkers(context.Background())
 	return cleared
 }
+
+// Stop accepting new jobs of GPost and job workers, and drain all the queues until
+// they're empty or ctx is done, it returns the number of jobs dropped.
+func Shutdown(ctx context.Context) (dropped int, err error) {
+	atomic.StoreInt32(&jobWorkersClosed, 1)
+	if GPost != nil {
+		dropped, err = GPost.Shutdown(ctx)
+	}
+	log.Println("Waiting for all async job workers to be cleared ...")
+	_, n, e := closeJobWorkers(ctx)
+	dropped += n
+	if err == nil {
+		err = e
+	}
+	return
+}
@@ -1,13 +1,39 @@
 package post
 
 import (
+	"context"
 	"fmt"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
+func TestWorkerContext(t *testing.T) {
+	var a int32
+	ctx, cancel := context.WithCancel(context.Background())
+	block := make(chan bool)
+	GPost.PutJob("testCtxGroup", func() {
+		<-block
+	})
+	err := GPost.PutJobCtx(ctx, "testCtxGroup", func(ctx context.Context, d *int32) {
+		atomic.StoreInt32(d, 1)
+	}, &a)
+	assert.Equal(t, nil, err)
+	cancel()
+	close(block)
+	time.Sleep(1 * time.Millisecond)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&a))
+
+	err = GPost.PutJobCtx(context.Background(), "testCtxGroup", func(ctx context.Context, d *int32) {
+		atomic.StoreInt32(d, 2)
+	}, &a)
+	assert.Equal(t, nil, err)
+	time.Sleep(1 * time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&a))
+}
+
 func TestWorker(t *testing.T) {
 	a := 0
 	GPost.PutJobStrict("testGroup", func(args ...interface{}) {
@@ -25,3 +51,23 @@ func TestWorker(t *testing.T) {
 	assert.Equal(t, 0, a)
 	Close()
 }
+
+func TestShutdown(t *testing.T) {
+	// job workers can be created again for the next test.
+	defer atomic.StoreInt32(&jobWorkersClosed, 0)
+	a := 0
+	block := make(chan bool)
+	GPost.PutJob("testShutdownGroup", func() { <-block })
+	for i := 0; i < 10; i++ {
+		GPost.PutJob("testShutdownGroup", func(d *int) { *d++ }, &a)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	dropped, err := Shutdown(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, 10, dropped)
+	assert.Equal(t, ErrClosed, GPost.PutJob("testShutdownGroup", func() {}))
+	close(block)
+	time.Sleep(1 * time.Millisecond)
+	assert.Equal(t, 0, a)
+}
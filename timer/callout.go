@@ -1,6 +1,7 @@
 package timer
 
 import (
+	"context"
 	"log"
 	"time"
 
@@ -22,6 +23,9 @@ type PostItem struct {
 	postFunc interface{}
 	postArgs []interface{}
 	Second   int64
+	// the item is traced as a child of the trace if any.
+	trace *post.TraceContext
+	added time.Time
 }
 
 type TimerMap struct {
@@ -46,7 +50,7 @@ func (this *PostItem) Run() {
 	if this.postFunc == nil {
 		return
 	}
-	GPost.PutJob(_TIMER_JOB_GROUP, this.postFunc, this.postArgs...)
+	postJob(traceEntry(this.trace, this.added), this.postFunc, this.postArgs)
 }
 
 func (this *PostItem) Cancel() {
@@ -54,10 +58,16 @@ func (this *PostItem) Cancel() {
 }
 
 func (this *TimerMap) Put(duration int64, f interface{}, postArgs []interface{}) *PostItem {
+	return this.put(nil, duration, f, postArgs)
+}
+
+func (this *TimerMap) put(ctx context.Context, duration int64, f interface{}, postArgs []interface{}) *PostItem {
 	item := &PostItem{
 		Second:   this.lastSecond + duration,
 		postFunc: f,
 		postArgs: postArgs,
+		trace:    post.TraceFromContext(ctx),
+		added:    time.Now(),
 	}
 	ok, quantity := this.itemQueue.Put(item)
 	if !ok {
@@ -100,9 +110,21 @@ func CallOut(duration int64, callback interface{}, args ...interface{}) *PostIte
 	return TSecond.Put(duration, callback, args)
 }
 
+// The callback is traced as a child of the trace in ctx, see post.WithTrace.
+func CallOutCtx(ctx context.Context, duration int64, callback interface{}, args ...interface{}) *PostItem {
+	if duration <= 0 {
+		return nil
+	}
+	return TSecond.put(ctx, duration, callback, args)
+}
+
 func init() {
 	TSecond = NewTimerMap(TMAP_CAPACITY)
 	GPost = post.GPost
+	// retries of post jobs are rescheduled by the timer.
+	post.SetRetryScheduler(func(d time.Duration, f func()) {
+		AddCallback(d, f)
+	})
 	now := time.Now()
 	d := time.Second - time.Nanosecond*time.Duration(now.Nanosecond()) + time.Nanosecond
 	AddCallback(d, func() {
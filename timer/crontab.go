@@ -235,7 +235,7 @@ func check() {
 	key := hour*60 + minute
 	for _, slot := range entryWheelMap[key] {
 		if slot.match(minute, hour, day, month, dayofweek) {
-			GPost.PutJob(_TIMER_JOB_GROUP, slot.cb, slot.params...)
+			postJob(nil, slot.cb, slot.params)
 		}
 	}
 }
@@ -294,7 +294,7 @@ func TestCrontab(stime string) (error, map[Handle]string) {
 		key := hour*60 + minute
 		for _, slot := range entryWheelMap[key] {
 			if slot.match(minute, hour, day, month, dayofweek) {
-				GPost.PutJob(_TIMER_JOB_GROUP, slot.cb, slot.params...)
+				postJob(nil, slot.cb, slot.params)
 			}
 		}
 	}
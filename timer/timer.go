@@ -4,11 +4,14 @@ package timer
 
 import (
 	"container/heap"
+	"context"
+	"errors"
 	"runtime"
 	"sync"
 	"time"
 
 	"github.com/TianQinS/fastapi/basic"
+	"github.com/TianQinS/fastapi/post"
 )
 
 const (
@@ -17,6 +20,10 @@ const (
 	TIME_INTERVAL = 10 * time.Millisecond
 	// the asynchronous worker key.
 	_TIMER_JOB_GROUP = "timer"
+	// the source of reports by the timer.
+	_REPORT_SOURCE = "timer"
+	// the name of spans for timer entries.
+	_TIMER_SPAN = "timer"
 )
 
 var (
@@ -35,6 +42,9 @@ type Timer struct {
 	params    []interface{}
 	repeat    bool
 	addseq    uint
+	// the entry is traced as a child of the trace if any, added is the time it's scheduled.
+	trace *post.TraceContext
+	added time.Time
 }
 
 type TimerHeap struct {
@@ -83,6 +93,10 @@ func (this *Timer) IsActive() bool {
 
 // Add a callback for the timer, it will be executed asynchronously.
 func Add(d time.Duration, f interface{}, repeat bool, args []interface{}) *Timer {
+	return add(nil, d, f, repeat, args)
+}
+
+func add(ctx context.Context, d time.Duration, f interface{}, repeat bool, args []interface{}) *Timer {
 	if d < TIME_INTERVAL {
 		d = TIME_INTERVAL
 	}
@@ -92,6 +106,8 @@ func Add(d time.Duration, f interface{}, repeat bool, args []interface{}) *Timer
 		asyncFunc: f,
 		params:    args,
 		repeat:    repeat,
+		trace:     post.TraceFromContext(ctx),
+		added:     time.Now(),
 	}
 
 	timerHeapLock.Lock()
@@ -112,6 +128,15 @@ func AddTimer(d time.Duration, f interface{}, args ...interface{}) *Timer {
 	return Add(d, f, true, args)
 }
 
+// The callback is traced as a child of the trace in ctx, see post.WithTrace.
+func AddCallbackCtx(ctx context.Context, d time.Duration, f interface{}, args ...interface{}) *Timer {
+	return add(ctx, d, f, false, args)
+}
+
+func AddTimerCtx(ctx context.Context, d time.Duration, f interface{}, args ...interface{}) *Timer {
+	return add(ctx, d, f, true, args)
+}
+
 // Tick once for timers.
 func Tick() {
 	defer timerHeapLock.Unlock()
@@ -132,7 +157,8 @@ func Tick() {
 			continue
 		}
 		if t.asyncFunc != nil {
-			GPost.PutJob(_TIMER_JOB_GROUP, t.asyncFunc, t.params...)
+			postJob(traceEntry(t.trace, t.added), t.asyncFunc, t.params)
+			t.added = now
 		}
 
 		if t.repeat {
@@ -150,11 +176,51 @@ func Tick() {
 	}
 }
 
+// Export the span of a timer entry, the job of the entry is traced as a child of it.
+func traceEntry(trace *post.TraceContext, added time.Time) context.Context {
+	if trace == nil {
+		return nil
+	}
+	span := trace.Child()
+	post.ExportSpan(post.SpanRecord{
+		TraceID:  span.TraceID,
+		SpanID:   span.SpanID,
+		ParentID: span.ParentID,
+		Name:     _TIMER_SPAN,
+		Enqueue:  added,
+		Wait:     time.Now().Sub(added),
+	})
+	return post.WithTrace(context.Background(), span)
+}
+
+// Post the callback to the timer group, the failure is reported unless the post is closed.
+func postJob(ctx context.Context, f interface{}, params []interface{}) {
+	var err error
+	if ctx == nil {
+		err = GPost.PutJob(_TIMER_JOB_GROUP, f, params...)
+	} else {
+		err = GPost.PutJobCtx(ctx, _TIMER_JOB_GROUP, f, params...)
+	}
+	if err != nil && !errors.Is(err, post.ErrClosed) {
+		basic.Report(_REPORT_SOURCE, err, params)
+	}
+}
+
+// Tick with panic recovery, the ticking routine keeps running after a panic.
+func safeTick() {
+	defer func() {
+		if info := recover(); info != nil {
+			basic.Report(_REPORT_SOURCE, basic.PanicError(info), nil)
+		}
+	}()
+	Tick()
+	GPost.PutQueue(Hook.Fire, "10ms")
+}
+
 func selfTickRoutine(tickInterval time.Duration) {
 	for {
 		start := time.Now()
-		Tick()
-		GPost.PutQueue(Hook.Fire, "10ms")
+		safeTick()
 		delta := tickInterval - time.Now().Sub(start)
 		if delta > 0 {
 			time.Sleep(delta)
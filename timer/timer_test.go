@@ -1,14 +1,17 @@
 package timer
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"runtime/pprof"
 	"testing"
 	"time"
 
+	"github.com/TianQinS/fastapi/post"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -35,6 +38,27 @@ func TestTimer(t *testing.T) {
 	}
 }
 
+func TestCallbackTrace(t *testing.T) {
+	exporter := post.NewMemoryTraceExporter(0)
+	post.SetTraceExporter(exporter)
+	defer post.SetTraceExporter(nil)
+
+	root := post.NewTrace()
+	done := make(chan struct{})
+	AddCallbackCtx(post.WithTrace(context.Background(), root), 20*time.Millisecond, func() {
+		close(done)
+	})
+	<-done
+	time.Sleep(20 * time.Millisecond)
+	spans := exporter.Trace(root.TraceID)
+	assert.Equal(t, 2, len(spans))
+	// the entry is the parent of the job.
+	assert.Equal(t, _TIMER_SPAN, spans[0].Name)
+	assert.Equal(t, root.SpanID, spans[0].ParentID)
+	assert.True(t, spans[0].Wait >= 20*time.Millisecond)
+	assert.Equal(t, spans[0].SpanID, spans[1].ParentID)
+}
+
 func TestCallbackSeq(t *testing.T) {
 	a := 0
 	d := time.Second
@@ -77,10 +101,11 @@ func TestCancelTimer(t *testing.T) {
 }
 
 func TestTimerPerformance(t *testing.T) {
-	f, err := os.Create("TestTimerPerformance.cpuprof")
+	f, err := os.Create(filepath.Join(t.TempDir(), "TestTimerPerformance.cpuprof"))
 	if err != nil {
 		panic(err)
 	}
+	defer f.Close()
 
 	pprof.StartCPUProfile(f)
 	duration := 10 * time.Second